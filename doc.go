@@ -1,14 +1,17 @@
 // Package driverlima implements a kutti driver for lima.
 // It uses the limactl CLI to talk to lima.
 //
-// For cluster networking, it uses the lima user-v2 network.
+// For cluster networking, it creates a lima user-v2 network
+// per cluster, by adding an entry to lima's networks.yaml.
 //
 // For nodes, it creates virtual machines from pre-built
 // "cloud" images, maintained by the companion
 // driver-lima-images project. These images are directly
 // passed to lima for caching/VM disk creation.
 //
-// Thus, the driver itself only downloads the list of
-// available images from the URL pointed to by the
-// ImagesSourceURL variable.
+// The list of available images is downloaded from the
+// URL pointed to by the ImagesSourceURL variable. The
+// images themselves can be fetched in advance, in which
+// case the driver places them in lima's download cache,
+// where lima finds them when creating VMs.
 package driverlima
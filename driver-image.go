@@ -2,6 +2,7 @@ package driverlima
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/kuttiproject/drivercore"
 )
@@ -12,27 +13,30 @@ func (vd *Driver) UpdateImageList() error {
 	return fetchimagelist()
 }
 
-// ValidK8sVersion returns true if the specified Kubernetes version is available.
+// ValidK8sVersion returns true if the specified Kubernetes version is available
+// for the host's architecture.
 func (vd *Driver) ValidK8sVersion(k8sversion string) bool {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return false
 	}
 
-	_, ok := imagedata.images[k8sversion]
+	_, ok := hostimages()[k8sversion]
 	return ok
 }
 
-// K8sVersions returns all Kubernetes versions currently supported by kutti.
+// K8sVersions returns all Kubernetes versions currently supported by kutti
+// for the host's architecture.
 func (vd *Driver) K8sVersions() []string {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return []string{}
 	}
 
-	result := make([]string, len(imagedata.images))
+	images := hostimages()
+	result := make([]string, len(images))
 	index := 0
-	for _, value := range imagedata.images {
+	for _, value := range images {
 		result[index] = value.ImageK8sVersion
 		index++
 	}
@@ -40,16 +44,18 @@ func (vd *Driver) K8sVersions() []string {
 	return result
 }
 
-// ListImages lists the currently available Images.
+// ListImages lists the currently available Images for the host's
+// architecture.
 func (vd *Driver) ListImages() ([]drivercore.Image, error) {
 	err := imageconfigmanager.Load()
 	if err != nil {
 		return []drivercore.Image{}, err
 	}
 
-	result := make([]drivercore.Image, len(imagedata.images))
+	images := hostimages()
+	result := make([]drivercore.Image, len(images))
 	index := 0
-	for _, value := range imagedata.images {
+	for _, value := range images {
 		result[index] = value
 		index++
 	}
@@ -58,6 +64,7 @@ func (vd *Driver) ListImages() ([]drivercore.Image, error) {
 }
 
 // GetImage returns an image corresponding to a Kubernetes version, or an error.
+// The image must be available for the host's architecture.
 func (vd *Driver) GetImage(k8sversion string) (drivercore.Image, error) {
 	err := imageconfigmanager.Load()
 	if err != nil {
@@ -69,5 +76,19 @@ func (vd *Driver) GetImage(k8sversion string) (drivercore.Image, error) {
 		return img, fmt.Errorf("no image present for K8s version %s", k8sversion)
 	}
 
+	platform, err := detecthost()
+	if err != nil {
+		return nil, err
+	}
+
+	if img.file(platform.arch) == nil {
+		return nil, fmt.Errorf(
+			"the image for K8s version %s is available for %s hosts, but not for this %s host",
+			k8sversion,
+			strings.Join(img.Archs(), ", "),
+			platform.arch,
+		)
+	}
+
 	return img, nil
 }
@@ -0,0 +1,98 @@
+package driverlima
+
+import (
+	"sort"
+	"testing"
+)
+
+const testMixedImageList = `{
+	"1.31": {
+		"ImageK8sVersion": "1.31",
+		"ImageSourceURL": "https://example.com/1.31/kutti-k8s-1.31.qcow2",
+		"ImageStatus": "NotDownloaded",
+		"ImageDeprecated": true
+	},
+	"1.32": {
+		"ImageK8sVersion": "1.32",
+		"ImageFiles": [
+			{"Location": "https://example.com/1.32/aarch64.qcow2", "Arch": "aarch64"},
+			{"Location": "https://example.com/1.32/x86_64.qcow2", "Arch": "x86_64"}
+		]
+	},
+	"1.33": {
+		"ImageK8sVersion": "1.33",
+		"ImageFiles": [
+			{"Location": "https://example.com/1.33/x86_64.qcow2", "Arch": "x86_64"}
+		]
+	}
+}`
+
+func TestImageListArchitectures(t *testing.T) {
+	d := testdriver(t)
+
+	err := imagedata.Deserialize([]byte(testMixedImageList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = imageconfigmanager.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.UpdateImageList()
+	})
+
+	legacy := imagedata.images["1.31"]
+	if legacy.ImageSourceURL != "" || len(legacy.ImageFiles) != 1 {
+		t.Fatalf("older image list entry not converted: %+v", legacy)
+	}
+	if file := legacy.file("aarch64"); file == nil || file.Location != "https://example.com/1.31/kutti-k8s-1.31.qcow2" {
+		t.Errorf("older image list entry not converted to an aarch64 image: %+v", legacy.ImageFiles)
+	}
+
+	tests := []struct {
+		goarch   string
+		versions []string
+	}{
+		{"arm64", []string{"1.31", "1.32"}},
+		{"amd64", []string{"1.32", "1.33"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.goarch, func(t *testing.T) {
+			pretendhost(t, "darwin", test.goarch)
+
+			versions := d.K8sVersions()
+			sort.Strings(versions)
+			if len(versions) != len(test.versions) {
+				t.Fatalf("expected versions %v, got %v", test.versions, versions)
+			}
+			for i := range versions {
+				if versions[i] != test.versions[i] {
+					t.Fatalf("expected versions %v, got %v", test.versions, versions)
+				}
+			}
+
+			images, err := d.ListImages()
+			if err != nil || len(images) != len(test.versions) {
+				t.Errorf("expected %v images, got %v (%v)", len(test.versions), len(images), err)
+			}
+
+			for _, version := range []string{"1.31", "1.32", "1.33"} {
+				expected := false
+				for _, v := range test.versions {
+					expected = expected || v == version
+				}
+
+				if d.ValidK8sVersion(version) != expected {
+					t.Errorf("expected ValidK8sVersion(%v) to be %v", version, expected)
+				}
+
+				_, err := d.GetImage(version)
+				if expected != (err == nil) {
+					t.Errorf("unexpected result from GetImage(%v): %v", version, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package driverlima
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/workspace"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// kubeconfigGuestPath is the kubeconfig that kubeadm writes on a
+// control-plane node.
+var kubeconfigGuestPath = "/etc/kubernetes/admin.conf"
+
+const (
+	apiServerPort = 6443
+	// apiServerName is a name always present in the API server
+	// certificate. It is used to verify the certificate when the server
+	// is reached through a forwarded port on the host.
+	apiServerName = "kubernetes"
+)
+
+// KubeconfigPath returns the path where FetchKubeconfig writes the
+// kubeconfig of a cluster.
+func (vd *Driver) KubeconfigPath(clustername string) (string, error) {
+	kubeconfigdir, err := workspace.ConfigSubDir("driver-lima-kubeconfigs")
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(kubeconfigdir, clustername+".conf"), nil
+}
+
+// FetchKubeconfig reads the admin kubeconfig from a control-plane Machine
+// of a cluster, points it at the host port to which the Machine's API
+// server port is forwarded, and writes it to the workspace configuration
+// directory. It returns the path of the written file.
+// The Machine must be running, and port 6443 must be forwarded.
+func (vd *Driver) FetchKubeconfig(machinename string, clustername string) (string, error) {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return vd.FetchKubeconfigContext(ctx, machinename, clustername)
+}
+
+// FetchKubeconfigContext is like FetchKubeconfig, but stops when ctx is
+// done.
+func (vd *Driver) FetchKubeconfigContext(ctx context.Context, machinename string, clustername string) (string, error) {
+	err := vd.validate()
+	if err != nil {
+		return "", err
+	}
+
+	m := &Machine{
+		driver:      vd,
+		name:        machinename,
+		clustername: clustername,
+	}
+
+	status := m.StatusContext(ctx)
+	if status != drivercore.MachineStatusRunning {
+		return "", fmt.Errorf("can only fetch kubeconfig when machine %v is running, but it is %v", machinename, status)
+	}
+
+	server, err := m.apiserveraddress()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := m.runwithresults(ctx, "sudo", "cat", kubeconfigGuestPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %v from machine %v", kubeconfigGuestPath, machinename)
+	}
+
+	kubeconfig, err := rewritekubeconfig([]byte(data), server)
+	if err != nil {
+		return "", err
+	}
+
+	kubeconfigpath, err := vd.KubeconfigPath(clustername)
+	if err != nil {
+		return "", err
+	}
+
+	// The file contains the cluster admin's credentials
+	err = os.WriteFile(kubeconfigpath, kubeconfig, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return kubeconfigpath, nil
+}
+
+// apiserveraddress returns the URL of the Machine's API server on the
+// host, according to the Machine's port forwarding rules.
+func (m *Machine) apiserveraddress() (string, error) {
+	forwards, err := m.ForwardedPorts()
+	if err != nil {
+		return "", err
+	}
+
+	// Lima uses the first rule that matches
+	for _, forward := range forwards {
+		if forward.Kind == ForwardedPortSSH || forward.Proto == "udp" {
+			continue
+		}
+		if apiServerPort < forward.MachinePortStart || apiServerPort > forward.MachinePortEnd {
+			continue
+		}
+
+		hostport := forward.HostPortStart + apiServerPort - forward.MachinePortStart
+		hostip := forward.HostIP
+		if ip := net.ParseIP(hostip); ip == nil || ip.IsUnspecified() {
+			hostip = "127.0.0.1"
+		}
+
+		return "https://" + net.JoinHostPort(hostip, strconv.Itoa(hostport)), nil
+	}
+
+	return "", fmt.Errorf("port %v of machine %v is not forwarded to the host", apiServerPort, m.name)
+}
+
+// rewritekubeconfig points every cluster in a kubeconfig at server.
+// Since the server address will not be in the API server certificate,
+// the certificate is verified against the name "kubernetes" instead,
+// unless the kubeconfig already specifies a name.
+func rewritekubeconfig(data []byte, server string) ([]byte, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse kubeconfig: %w", err)
+	}
+
+	if document.Kind != yaml.DocumentNode || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("could not parse kubeconfig: not a YAML mapping")
+	}
+
+	clusters := mappingvalue(document.Content[0], "clusters")
+	if clusters == nil || clusters.Kind != yaml.SequenceNode || len(clusters.Content) == 0 {
+		return nil, fmt.Errorf("kubeconfig does not contain any clusters")
+	}
+
+	for _, entry := range clusters.Content {
+		cluster := mappingvalue(entry, "cluster")
+		if cluster == nil || cluster.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("kubeconfig contains an invalid cluster entry")
+		}
+
+		setmappingvalue(cluster, "server", server)
+		if mappingvalue(cluster, "tls-server-name") == nil {
+			setmappingvalue(cluster, "tls-server-name", apiServerName)
+		}
+	}
+
+	return yaml.Marshal(&document)
+}
+
+// mappingvalue returns the value of a key in a YAML mapping node, or nil.
+func mappingvalue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setmappingvalue sets a key in a YAML mapping node to a string.
+func setmappingvalue(node *yaml.Node, key string, value string) {
+	scalar := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = scalar
+			return
+		}
+	}
+	node.Content = append(
+		node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		scalar,
+	)
+}
@@ -0,0 +1,174 @@
+package driverlima
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testKubeconfig = `apiVersion: v1
+clusters:
+- cluster:
+    certificate-authority-data: Q0EgREFUQQ==
+    server: https://192.168.104.2:6443
+  name: kubernetes
+contexts:
+- context:
+    cluster: kubernetes
+    user: kubernetes-admin
+  name: kubernetes-admin@kubernetes
+current-context: kubernetes-admin@kubernetes
+kind: Config
+preferences: {}
+users:
+- name: kubernetes-admin
+  user:
+    client-certificate-data: Q0VSVA==
+    client-key-data: S0VZ
+`
+
+func TestRewriteKubeconfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		kubeconfig  string
+		wantservers []string
+		wantnames   []string
+		wanterr     string
+	}{
+		{
+			name:        "kubeadm",
+			kubeconfig:  testKubeconfig,
+			wantservers: []string{"https://127.0.0.1:16443"},
+			wantnames:   []string{"kubernetes"},
+		},
+		{
+			name: "existing server name",
+			kubeconfig: `clusters:
+- cluster:
+    server: https://10.0.0.1:6443
+    tls-server-name: control-plane
+- cluster:
+    server: https://10.0.0.2:6443
+`,
+			wantservers: []string{"https://127.0.0.1:16443", "https://127.0.0.1:16443"},
+			wantnames:   []string{"control-plane", "kubernetes"},
+		},
+		{name: "no clusters", kubeconfig: "kind: Config\n", wanterr: "does not contain any clusters"},
+		{name: "invalid cluster", kubeconfig: "clusters:\n- name: x\n", wanterr: "invalid cluster"},
+		{name: "not yaml", kubeconfig: "clusters: [", wanterr: "could not parse"},
+		{name: "not a mapping", kubeconfig: "- a\n", wanterr: "not a YAML mapping"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := rewritekubeconfig([]byte(test.kubeconfig), "https://127.0.0.1:16443")
+			if test.wanterr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wanterr) {
+					t.Errorf("expected error containing %q, got %v", test.wanterr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checkkubeconfig(t, data, test.wantservers, test.wantnames)
+		})
+	}
+}
+
+// checkkubeconfig checks the server and tls-server-name of each cluster
+// in a kubeconfig.
+func checkkubeconfig(t *testing.T, data []byte, servers []string, names []string) {
+	t.Helper()
+
+	var kubeconfig struct {
+		Clusters []struct {
+			Cluster map[string]string `yaml:"cluster"`
+		} `yaml:"clusters"`
+	}
+	err := yaml.Unmarshal(data, &kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(kubeconfig.Clusters) != len(servers) {
+		t.Fatalf("expected %v clusters, got %v", len(servers), len(kubeconfig.Clusters))
+	}
+	for i, entry := range kubeconfig.Clusters {
+		if entry.Cluster["server"] != servers[i] || entry.Cluster["tls-server-name"] != names[i] {
+			t.Errorf("unexpected cluster %v: %v", i, entry.Cluster)
+		}
+	}
+}
+
+func TestFetchKubeconfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake limactl runs guest commands on the host, which needs cat")
+	}
+
+	// The fake shares the host's filesystem
+	guestpath := filepath.Join(t.TempDir(), "admin.conf")
+	err := os.WriteFile(guestpath, []byte(testKubeconfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedpath := kubeconfigGuestPath
+	kubeconfigGuestPath = guestpath
+	t.Cleanup(func() { kubeconfigGuestPath = savedpath })
+
+	d := testdriver(t)
+	m := testmachine(t, d, "cp1")
+
+	_, err = d.FetchKubeconfig("cp1", "test")
+	if err == nil || !strings.Contains(err.Error(), "running") {
+		t.Errorf("expected error fetching kubeconfig from a stopped machine, got %v", err)
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.FetchKubeconfig("cp1", "test")
+	if err == nil || !strings.Contains(err.Error(), "not forwarded") {
+		t.Errorf("expected error when API server port is not forwarded, got %v", err)
+	}
+
+	err = m.Stop()
+	if err == nil {
+		err = m.ForwardPort(16443, 6443)
+	}
+	if err == nil {
+		err = m.Start()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kubeconfigpath, err := d.FetchKubeconfig("cp1", "test")
+	if err != nil {
+		t.Fatalf("FetchKubeconfig failed: %v", err)
+	}
+
+	expectedpath, _ := d.KubeconfigPath("test")
+	if kubeconfigpath != expectedpath {
+		t.Errorf("expected kubeconfig at %v, got %v", expectedpath, kubeconfigpath)
+	}
+
+	data, err := os.ReadFile(kubeconfigpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkkubeconfig(t, data, []string{"https://127.0.0.1:16443"}, []string{"kubernetes"})
+	if !strings.Contains(string(data), "client-key-data: S0VZ") {
+		t.Error("credentials not preserved in kubeconfig")
+	}
+
+	if info, err := os.Stat(kubeconfigpath); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected kubeconfig mode 0600, got %v", info.Mode().Perm())
+	}
+}
@@ -1,6 +1,7 @@
 package driverlima
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -14,22 +15,41 @@ func (vd *Driver) QualifiedMachineName(machinename string, clustername string) s
 	return clustername + "-" + machinename
 }
 
-// GetMachine returns a Machine in a cluster.
+// GetMachine returns a Machine in a cluster. If the Machine's lima
+// instance does not exist, an error matching ErrInstanceNotFound is
+// returned.
 func (vd *Driver) GetMachine(machinename string, clustername string) (drivercore.Machine, error) {
 	err := vd.validate()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Machine{
+	m := &Machine{
 		driver:      vd,
 		name:        machinename,
 		clustername: clustername,
-	}, nil
+	}
+
+	ctx, stop := interruptcontext()
+	defer stop()
+	err = m.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
 // DeleteMachine deletes a Machine in a cluster.
 func (vd *Driver) DeleteMachine(machinename string, clustername string) error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return vd.DeleteMachineContext(ctx, machinename, clustername)
+}
+
+// DeleteMachineContext is like DeleteMachine, but kills limactl if ctx is
+// done first.
+func (vd *Driver) DeleteMachineContext(ctx context.Context, machinename string, clustername string) error {
 	err := vd.validate()
 	if err != nil {
 		return err
@@ -45,7 +65,7 @@ func (vd *Driver) DeleteMachine(machinename string, clustername string) error {
 		vd.QualifiedMachineName(machinename, clustername),
 	}
 
-	_, err = vd.runwithresults(limactlparams...)
+	_, err = vd.runwithresults(ctx, limactlparams...)
 	if err != nil {
 		return errors.Wrap(err, "could not delete lima vm")
 	}
@@ -55,12 +75,25 @@ func (vd *Driver) DeleteMachine(machinename string, clustername string) error {
 		return errors.Wrap(err, "machine file not deleted")
 	}
 
+	err = vd.forgetmachineresources(machinename, clustername)
+	if err != nil {
+		return errors.Wrap(err, "machine resources not deleted")
+	}
+
 	return nil
 }
 
 // NewMachine creates a new Machine in a cluster, usually using an Image
 // for the supplied Kubernetes version.
 func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion string) (drivercore.Machine, error) {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return vd.NewMachineContext(ctx, machinename, clustername, k8sversion)
+}
+
+// NewMachineContext is like NewMachine, but kills limactl if ctx is done
+// first. Creating a Machine may involve downloading its image.
+func (vd *Driver) NewMachineContext(ctx context.Context, machinename string, clustername string, k8sversion string) (drivercore.Machine, error) {
 	err := vd.validate()
 	if err != nil {
 		return nil, err
@@ -76,12 +109,45 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 		return nil, fmt.Errorf("unknown error verifying image for Kubernetes version %v", k8sversion)
 	}
 
+	imagefile := localimage.file(vd.platform.arch)
+	if imagefile == nil {
+		return nil, fmt.Errorf("no %v image for Kubernetes version %v", vd.platform.arch, k8sversion)
+	}
+
 	machinefile, err := machineFilePath(vd.QualifiedMachineName(machinename, clustername))
 	if err != nil {
 		return nil, errors.Wrap(err, "machine file not accessible")
 	}
 
-	err = writemanifest(machinefile, localimage.ImageSourceURL)
+	lm, err := newmanifest()
+	if err != nil {
+		return nil, err
+	}
+
+	lm.VMType = vd.platform.vmType
+	lm.Arch = vd.platform.arch
+	lm.setImage(imagefile.Location, imagefile.Digest)
+
+	resources, err := vd.configuredresources(machinename, clustername)
+	if err != nil {
+		return nil, errors.Wrap(err, "machine resources not accessible")
+	}
+
+	err = resources.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	lm.applyResources(resources)
+
+	networkname, err := vd.clusternetworkname(clustername)
+	if err != nil {
+		return nil, errors.Wrap(err, "lima network configuration not accessible")
+	}
+
+	lm.Networks = []manifestNetwork{{Lima: networkname}}
+
+	err = writemanifest(machinefile, lm)
 	if err != nil {
 		return nil, errors.Wrap(err, "machine file not written")
 	}
@@ -92,15 +158,11 @@ func (vd *Driver) NewMachine(machinename string, clustername string, k8sversion
 		machinefile,
 	}
 
-	result, err := vd.runwithresults(limactlparams...)
+	_, err = vd.runwithresults(ctx, limactlparams...)
 	if err != nil {
 		// TODO: Consider doing a compensatory `limactl rm`.
 		// Not risking it in the current version.
-		errMsg := result.LastLogErrorMessage()
-		if errMsg == "" {
-			errMsg = "error during limactl create"
-		}
-		return nil, errors.Wrap(err, errMsg)
+		return nil, errors.Wrap(err, "error during limactl create")
 	}
 
 	return &Machine{
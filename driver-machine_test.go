@@ -0,0 +1,115 @@
+package driverlima
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func TestNewMachine(t *testing.T) {
+	d := testdriver(t)
+
+	m := testmachine(t, d, "new1")
+	if m.Status() != drivercore.MachineStatusStopped {
+		t.Errorf("expected new machine to be %v, got %v", drivercore.MachineStatusStopped, m.Status())
+	}
+
+	machinefile, err := machineFilePath(m.qName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(machinefile)
+	if err != nil {
+		t.Errorf("machine file not written: %v", err)
+	}
+
+	config := fakeinstanceconfig(t, m.qName())
+	images, _ := config["images"].([]any)
+	if len(images) == 0 {
+		t.Fatal("no images in instance configuration")
+	}
+	location := images[0].(map[string]any)["location"]
+	expectedlocation := imagedata.images[testK8sVersion].file(d.platform.arch).Location
+	if location != expectedlocation {
+		t.Errorf("expected image location %v, got %v", expectedlocation, location)
+	}
+	digest := images[0].(map[string]any)["digest"]
+	if digest != testimagedigest(d.platform.arch) {
+		t.Errorf("expected image digest %v, got %v", testimagedigest(d.platform.arch), digest)
+	}
+
+	_, err = d.NewMachine("new1", "test", testK8sVersion)
+	if err == nil {
+		t.Error("expected error creating a machine that already exists")
+	}
+
+	_, err = d.NewMachine("new2", "test", "0.0")
+	if err == nil {
+		t.Error("expected error creating a machine with an unknown Kubernetes version")
+	}
+}
+
+func TestDeleteMachine(t *testing.T) {
+	d := testdriver(t)
+
+	m := testmachine(t, d, "delete1")
+	machinefile, _ := machineFilePath(m.qName())
+
+	err := d.DeleteMachine("delete1", "test")
+	if err != nil {
+		t.Fatalf("DeleteMachine failed: %v", err)
+	}
+
+	_, err = loadfakeinstance(m.qName())
+	if err == nil {
+		t.Error("lima instance not deleted")
+	}
+	_, err = os.Stat(machinefile)
+	if !os.IsNotExist(err) {
+		t.Error("machine file not deleted")
+	}
+
+	err = d.DeleteMachine("delete1", "test")
+	if err == nil {
+		t.Error("expected error deleting a machine that does not exist")
+	}
+}
+
+func TestNewMachinePlatform(t *testing.T) {
+	d := testdriver(t)
+
+	m := testmachine(t, d, "platform1")
+	config := fakeinstanceconfig(t, m.qName())
+	if config["vmType"] != d.platform.vmType || config["arch"] != d.platform.arch {
+		t.Errorf(
+			"expected vmType %v and arch %v, got %v and %v",
+			d.platform.vmType, d.platform.arch, config["vmType"], config["arch"],
+		)
+	}
+
+	images := config["images"].([]any)
+	if images[0].(map[string]any)["arch"] != d.platform.arch {
+		t.Errorf("expected image arch %v, got %v", d.platform.arch, images[0])
+	}
+
+}
+
+func TestGetMachine(t *testing.T) {
+	d := testdriver(t)
+	testmachine(t, d, "get1")
+
+	dm, err := d.GetMachine("get1", "test")
+	if err != nil {
+		t.Fatalf("GetMachine failed: %v", err)
+	}
+	if dm.Status() != drivercore.MachineStatusStopped {
+		t.Errorf("expected machine to be %v, got %v", drivercore.MachineStatusStopped, dm.Status())
+	}
+
+	_, err = d.GetMachine("get2", "test")
+	if !errors.Is(err, ErrInstanceNotFound) {
+		t.Errorf("expected ErrInstanceNotFound getting a machine that does not exist, got %v", err)
+	}
+}
@@ -1,18 +1,135 @@
 package driverlima
 
-import "github.com/kuttiproject/drivercore"
+import (
+	"fmt"
+	"net"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
+)
+
+// defaultNetworkName is the lima network used by machines in clusters
+// which do not have their own network.
+const defaultNetworkName = "user-v2"
 
 // QualifiedNetworkName returns a unique Network name for a cluster.
 func (vd *Driver) QualifiedNetworkName(clustername string) string {
-	panic("QualifiedNetworkName not implemented for lima driver")
+	return "kutti-" + clustername
 }
 
 // DeleteNetwork deletes the Network for a cluster.
+// The network is removed from lima's networks.yaml, and its subnet is
+// released. Clusters created by earlier versions of the driver do not
+// have a network, and are ignored.
 func (vd *Driver) DeleteNetwork(clustername string) error {
-	panic("DeleteNetwork not implemented for lima driver")
+	err := vd.validate()
+	if err != nil {
+		return err
+	}
+
+	netname := vd.QualifiedNetworkName(clustername)
+	return withnetworkconfig(func(nc *limaNetworkConfig) (bool, error) {
+		removed := nc.remove(netname)
+		if !removed {
+			kuttilog.Printf(kuttilog.Verbose, "Lima network %v does not exist.", netname)
+		}
+
+		err := withsubnetallocator(nc, netname, func(sa *subnetAllocator) error {
+			sa.release(clustername)
+			return nil
+		})
+		return removed, err
+	})
 }
 
 // NewNetwork creates a new Network for a cluster.
+// The network is added to lima's networks.yaml as a user-v2 network, on a
+// subnet from ClusterNetworkPool which does not overlap any other lima
+// network, any network on the host, or any other cluster's network.
 func (vd *Driver) NewNetwork(clustername string) (drivercore.Network, error) {
-	panic("NewNetwork not implemented for lima driver")
+	err := vd.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	netname := vd.QualifiedNetworkName(clustername)
+	result := &Network{name: netname, clustername: clustername}
+
+	err = withnetworkconfig(func(nc *limaNetworkConfig) (bool, error) {
+		existing, err := nc.get(netname)
+		if err != nil {
+			return false, err
+		}
+		if existing != nil {
+			return false, fmt.Errorf("lima network %v already exists", netname)
+		}
+
+		var subnet *net.IPNet
+		err = withsubnetallocator(nc, netname, func(sa *subnetAllocator) error {
+			var err error
+			subnet, err = sa.allocate(clustername)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+
+		network, err := newusernetwork(subnet)
+		if err != nil {
+			return false, err
+		}
+
+		result.netCIDR = subnet.String()
+		return true, nc.set(netname, network)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NetworkCIDR returns the IPv4 address range of the Network for a
+// cluster. Pod and service address ranges for the cluster should be
+// chosen so as not to overlap it.
+func (vd *Driver) NetworkCIDR(clustername string) (string, error) {
+	netname := vd.QualifiedNetworkName(clustername)
+
+	var network *limaNetwork
+	err := withnetworkconfig(func(nc *limaNetworkConfig) (bool, error) {
+		var err error
+		network, err = nc.get(netname)
+		return false, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if network == nil || network.subnet() == nil {
+		return "", fmt.Errorf("cluster %v does not have a network", clustername)
+	}
+
+	return network.subnet().String(), nil
+}
+
+// clusternetworkname returns the name of the lima network that machines in
+// a cluster should be attached to.
+func (vd *Driver) clusternetworkname(clustername string) (string, error) {
+	netname := vd.QualifiedNetworkName(clustername)
+
+	var network *limaNetwork
+	err := withnetworkconfig(func(nc *limaNetworkConfig) (bool, error) {
+		var err error
+		network, err = nc.get(netname)
+		return false, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if network == nil {
+		return defaultNetworkName, nil
+	}
+
+	return netname, nil
 }
@@ -0,0 +1,221 @@
+package driverlima
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const testNetworksConfig = `# Managed by hand
+paths:
+  varRun: /private/var/run/lima
+networks:
+  user-v2:
+    mode: user-v2
+    gateway: 192.168.104.1
+    netmask: 255.255.255.0
+  shared:
+    mode: shared
+    gateway: 192.168.105.1
+    dhcpEnd: 192.168.105.254
+    netmask: 255.255.255.0
+  # Someone else's network
+  other:
+    mode: user-v2
+    gateway: 192.168.106.1
+    netmask: 255.255.254.0
+`
+
+// testnetworksconfig replaces lima's networks.yaml for the duration of a
+// test, and returns its path.
+func testnetworksconfig(t *testing.T, content string) string {
+	t.Helper()
+
+	configpath, err := limaConfigPath(networksConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(strings.TrimSuffix(configpath, networksConfigFile), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(configpath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Remove(configpath) })
+	return configpath
+}
+
+func loadtestnetworks(t *testing.T, configpath string) map[string]*limaNetwork {
+	t.Helper()
+
+	nc, err := loadnetworkconfig(configpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err := nc.networks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return networks
+}
+
+func TestNetworks(t *testing.T) {
+	d := testdriver(t)
+	configpath := testnetworksconfig(t, testNetworksConfig)
+
+	network1, err := d.NewNetwork("net1")
+	if err != nil {
+		t.Fatalf("NewNetwork failed: %v", err)
+	}
+	if network1.Name() != d.QualifiedNetworkName("net1") {
+		t.Errorf("unexpected network name %v", network1.Name())
+	}
+	if network1.CIDR() != "192.168.128.0/24" {
+		t.Errorf("expected first free subnet 192.168.128.0/24, got %v", network1.CIDR())
+	}
+
+	network2, err := d.NewNetwork("net2")
+	if err != nil {
+		t.Fatalf("NewNetwork failed: %v", err)
+	}
+	// 192.168.129.0/24 is routed by the test host
+	if network2.CIDR() != "192.168.130.0/24" {
+		t.Errorf("expected next free subnet 192.168.130.0/24, got %v", network2.CIDR())
+	}
+
+	cidr, err := d.NetworkCIDR("net2")
+	if err != nil || cidr != network2.CIDR() {
+		t.Errorf("expected NetworkCIDR %v, got %v, %v", network2.CIDR(), cidr, err)
+	}
+
+	_, err = d.NewNetwork("net1")
+	if err == nil {
+		t.Error("expected error creating a network that already exists")
+	}
+
+	networks := loadtestnetworks(t, configpath)
+	entry := networks[network1.Name()]
+	if entry == nil || entry.Mode != "user-v2" || entry.Gateway != "192.168.128.1" || entry.Netmask != "255.255.255.0" {
+		t.Errorf("unexpected networks.yaml entry %+v", entry)
+	}
+	if len(networks) != 5 {
+		t.Errorf("expected 5 networks, got %v", len(networks))
+	}
+
+	data, _ := os.ReadFile(configpath)
+	for _, preserved := range []string{"# Managed by hand", "# Someone else's network", "varRun: /private/var/run/lima"} {
+		if !strings.Contains(string(data), preserved) {
+			t.Errorf("%q not preserved in networks.yaml", preserved)
+		}
+	}
+
+	network2.SetCIDR("192.168.128.0/23")
+	if network2.CIDR() != "192.168.130.0/24" {
+		t.Errorf("expected SetCIDR with an overlapping range to be ignored, got %v", network2.CIDR())
+	}
+
+	network2.SetCIDR("10.200.0.0/16")
+	if network2.CIDR() != "10.200.0.0/16" {
+		t.Errorf("expected CIDR 10.200.0.0/16 after SetCIDR, got %v", network2.CIDR())
+	}
+	entry = loadtestnetworks(t, configpath)[network2.Name()]
+	if entry.subnet().String() != "10.200.0.0/16" || entry.Gateway != "10.200.0.1" {
+		t.Errorf("unexpected networks.yaml entry after SetCIDR: %+v", entry)
+	}
+
+	err = d.DeleteNetwork("net2")
+	if err != nil {
+		t.Fatalf("DeleteNetwork failed: %v", err)
+	}
+	if _, ok := loadtestnetworks(t, configpath)[network2.Name()]; ok {
+		t.Error("network not removed from networks.yaml")
+	}
+
+	err = d.DeleteNetwork("net2")
+	if err != nil {
+		t.Errorf("expected no error deleting a network that does not exist, got %v", err)
+	}
+
+	_, err = d.NetworkCIDR("net2")
+	if err == nil {
+		t.Error("expected error getting the CIDR of a deleted network")
+	}
+
+	d.DeleteNetwork("net1")
+}
+
+func TestNetworksWithoutConfig(t *testing.T) {
+	d := testdriver(t)
+
+	configpath, err := limaConfigPath(networksConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(configpath)
+	t.Cleanup(func() { os.Remove(configpath) })
+
+	network, err := d.NewNetwork("net3")
+	if err != nil {
+		t.Fatalf("NewNetwork failed: %v", err)
+	}
+	t.Cleanup(func() { d.DeleteNetwork("net3") })
+
+	networks := loadtestnetworks(t, configpath)
+	if networks[defaultNetworkName] == nil || networks[network.Name()] == nil {
+		t.Errorf("expected default and cluster networks in new networks.yaml, got %v", networks)
+	}
+
+	// Lima's other settings are left to lima
+	data, err := os.ReadFile(configpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]any{}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config) != 1 {
+		t.Errorf("expected only networks in new networks.yaml, got %v", config)
+	}
+
+	_, defaultsubnet, _ := net.ParseCIDR("192.168.104.0/24")
+	_, subnet, _ := net.ParseCIDR(network.CIDR())
+	if defaultsubnet.Contains(subnet.IP) {
+		t.Errorf("cluster network %v overlaps default network", network.CIDR())
+	}
+}
+
+func TestNewMachineNetwork(t *testing.T) {
+	d := testdriver(t)
+	testnetworksconfig(t, testNetworksConfig)
+
+	// Clusters without a network use the default network
+	m := testmachine(t, d, "net1")
+	networks := fakeinstanceconfig(t, m.qName())["networks"].([]any)
+	if networks[0].(map[string]any)["lima"] != defaultNetworkName {
+		t.Errorf("expected network %v, got %v", defaultNetworkName, networks)
+	}
+
+	network, err := d.NewNetwork("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.DeleteNetwork("test") })
+
+	m = testmachine(t, d, "net2")
+	networks = fakeinstanceconfig(t, m.qName())["networks"].([]any)
+	if len(networks) != 1 || networks[0].(map[string]any)["lima"] != network.Name() {
+		t.Errorf("expected network %v, got %v", network.Name(), networks)
+	}
+}
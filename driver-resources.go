@@ -0,0 +1,51 @@
+package driverlima
+
+// MachineResources specifies the CPU count, memory and disk size of a
+// Machine.
+// A zero value for any field means that the value is inherited. Settings
+// for a Machine inherit from settings for its cluster, which inherit from
+// the driver defaults, which in turn inherit from the built-in node
+// template.
+type MachineResources struct {
+	CPUs      int `json:"cpus,omitempty"`
+	MemoryMiB int `json:"memoryMiB,omitempty"`
+	DiskGiB   int `json:"diskGiB,omitempty"`
+}
+
+// SetDefaultResources sets the resources of new Machines in all clusters.
+func (vd *Driver) SetDefaultResources(resources MachineResources) error {
+	return setresources(resources, func(rcd *resourceconfigdata) {
+		rcd.Defaults = resources
+	})
+}
+
+// SetClusterResources sets the resources of new Machines in a cluster.
+func (vd *Driver) SetClusterResources(clustername string, resources MachineResources) error {
+	return setresources(resources, func(rcd *resourceconfigdata) {
+		rcd.Clusters[clustername] = resources
+	})
+}
+
+// SetMachineResources sets the resources of a Machine in a cluster. The
+// settings are used when the Machine is created by NewMachine.
+func (vd *Driver) SetMachineResources(machinename string, clustername string, resources MachineResources) error {
+	return setresources(resources, func(rcd *resourceconfigdata) {
+		rcd.Machines[vd.QualifiedMachineName(machinename, clustername)] = resources
+	})
+}
+
+// MachineResources returns the resources that NewMachine will use to create
+// a Machine in a cluster.
+func (vd *Driver) MachineResources(machinename string, clustername string) (MachineResources, error) {
+	resources, err := vd.configuredresources(machinename, clustername)
+	if err != nil {
+		return resources, err
+	}
+
+	lm, err := newmanifest()
+	if err != nil {
+		return resources, err
+	}
+
+	return resources.inherit(lm.resources()), nil
+}
@@ -0,0 +1,67 @@
+package driverlima
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestMachineResources(t *testing.T) {
+	d := testdriver(t)
+	t.Cleanup(func() {
+		resourceconfigmanager.Load()
+		resourcedata.SetDefaults()
+		resourceconfigmanager.Save()
+	})
+
+	err := d.SetDefaultResources(MachineResources{MemoryMiB: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.SetClusterResources("test", MachineResources{CPUs: 1, MemoryMiB: 1536})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.SetMachineResources("sized1", "test", MachineResources{DiskGiB: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := MachineResources{CPUs: 1, MemoryMiB: 1536, DiskGiB: 20}
+	resources, err := d.MachineResources("sized1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources != expected {
+		t.Errorf("expected resources %+v, got %+v", expected, resources)
+	}
+
+	// Values not set anywhere come from the node template
+	resources, err = d.MachineResources("sized2", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources != (MachineResources{CPUs: 2, MemoryMiB: 1024, DiskGiB: 100}) {
+		t.Errorf("unexpected inherited resources %+v", resources)
+	}
+
+	m := testmachine(t, d, "sized1")
+
+	config := fakeinstanceconfig(t, m.qName())
+	if config["cpus"] != 1 || config["memory"] != "1536MiB" || config["disk"] != "20GiB" {
+		t.Errorf("resources not written to manifest: cpus %v, memory %v, disk %v", config["cpus"], config["memory"], config["disk"])
+	}
+
+	if reported := m.Resources(); reported != expected {
+		t.Errorf("expected machine to report %+v, got %+v", expected, reported)
+	}
+
+	err = d.SetMachineResources("sized1", "test", MachineResources{CPUs: runtime.NumCPU() + 1})
+	if err == nil {
+		t.Error("expected error requesting more CPUs than the host has")
+	}
+
+	err = d.SetMachineResources("sized1", "test", MachineResources{MemoryMiB: -1})
+	if err == nil {
+		t.Error("expected error requesting negative memory")
+	}
+}
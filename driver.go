@@ -8,9 +8,11 @@ const (
 // Driver implements the drivercore.Driver interface for Lima.
 type Driver struct {
 	limactlpath  string
+	platform     hostPlatform
 	validated    bool
 	status       string
 	errormessage string
+	progress     ProgressFunc
 }
 
 // Name returns "lima"
@@ -23,14 +25,16 @@ func (vd *Driver) Description() string {
 	return driverDescription
 }
 
-// UsesPerClusterNetworking returns false.
-// This driver uses the Lima "user-v2" network for all clusters.
+// UsesPerClusterNetworking returns true.
+// This driver creates a Lima "user-v2" network for each cluster.
 func (vd *Driver) UsesPerClusterNetworking() bool {
-	return false
+	return true
 }
 
 // UsesNATNetworking returns true.
-// This driver uses port forwarding, though not user-defined.
+// Each cluster gets its own Lima "user-v2" network, which machines reach
+// the host through. Ports are forwarded to the host per request, through
+// ForwardPort.
 func (vd *Driver) UsesNATNetworking() bool {
 	return true
 }
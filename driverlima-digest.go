@@ -0,0 +1,73 @@
+package driverlima
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var digestPattern = regexp.MustCompile(`^(sha256:[0-9a-f]{64}|sha512:[0-9a-f]{128})$`)
+
+// validdigest checks that a digest is in the form "algorithm:hex",
+// where the algorithm is sha256 or sha512. These are the digests that
+// lima can verify.
+func validdigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid digest '%v': expected sha256:<hex> or sha512:<hex>", digest)
+	}
+	return nil
+}
+
+// newdigester returns a hash for the algorithm of a digest.
+func newdigester(digest string) (hash.Hash, error) {
+	err := validdigest(digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(digest, "sha512:") {
+		return sha512.New(), nil
+	}
+	return sha256.New(), nil
+}
+
+// digestof formats the sum of a hash created by newdigester as a digest.
+func digestof(h hash.Hash) string {
+	algorithm := "sha256"
+	if h.Size() == sha512.Size {
+		algorithm = "sha512"
+	}
+	return algorithm + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyfiledigest checks the contents of a file against a digest.
+func verifyfiledigest(filepath string, digest string) error {
+	h, err := newdigester(digest)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+
+	actual := digestof(h)
+	if actual != digest {
+		return fmt.Errorf("digest mismatch for %v: expected %v, got %v", filepath, digest, actual)
+	}
+
+	return nil
+}
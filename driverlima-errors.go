@@ -0,0 +1,111 @@
+package driverlima
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+// Errors returned by the driver wrap these errors where the cause of a
+// failure is known, so that it can be checked with errors.Is.
+var (
+	// ErrLimactlNotFound is returned when limactl is not installed, or
+	// cannot be run.
+	ErrLimactlNotFound = errors.New("limactl not found")
+	// ErrInstanceNotFound is returned when a lima instance does not exist.
+	ErrInstanceNotFound = errors.New("lima instance not found")
+	// ErrInstanceExists is returned when creating a lima instance that
+	// already exists.
+	ErrInstanceExists = errors.New("lima instance already exists")
+	// ErrInstanceNotRunning is returned when an operation needs a running
+	// lima instance.
+	ErrInstanceNotRunning = errors.New("lima instance not running")
+	// ErrPortInUse is returned when a host port is already in use.
+	// A *PortConflictError matches it.
+	ErrPortInUse = errors.New("port already in use")
+	// ErrTimeout is returned when limactl does not complete in time.
+	// A *TimeoutError matches it.
+	ErrTimeout = errors.New("limactl timed out")
+	// ErrSubnetPoolExhausted is returned when no free subnet is left in
+	// ClusterNetworkPool for a new cluster network.
+	ErrSubnetPoolExhausted = errors.New("no free subnet left in cluster network pool")
+)
+
+// limactlErrorKinds classify the messages of fatal and error log entries
+// written by limactl.
+var limactlErrorKinds = []struct {
+	pattern *regexp.Regexp
+	kind    error
+}{
+	{regexp.MustCompile(`instance "[^"]*" does not exist`), ErrInstanceNotFound},
+	{regexp.MustCompile(`instance "[^"]*" already exists`), ErrInstanceExists},
+	{regexp.MustCompile(`instance "[^"]*" (is stopped|status is not "Running")|expected status "Running"`), ErrInstanceNotRunning},
+	{regexp.MustCompile(`address already in use`), ErrPortInUse},
+}
+
+// LimactlError is returned when limactl exits with an error. If the cause
+// could be classified from limactl's log, the error matches one of the
+// errors above with errors.Is. The exit error matches *exec.ExitError
+// with errors.As.
+type LimactlError struct {
+	// Command is the limactl command, such as "create".
+	Command  string
+	ExitCode int
+	// Message is the message of the last fatal or error log entry, if any.
+	Message string
+	// LogEntries are the log entries written by limactl.
+	LogEntries []LogEntry
+
+	kind error
+	err  error
+}
+
+func (e *LimactlError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("limactl %v failed with exit code %v", e.Command, e.ExitCode)
+	}
+	return fmt.Sprintf("limactl %v: %v", e.Command, e.Message)
+}
+
+func (e *LimactlError) Unwrap() []error {
+	if e.kind == nil {
+		return []error{e.err}
+	}
+	return []error{e.kind, e.err}
+}
+
+// newlimactlerror classifies the failure of a limactl command from the
+// log entries it wrote.
+func newlimactlerror(command string, err error, entries []LogEntry) *LimactlError {
+	result := &LimactlError{
+		Command:    command,
+		ExitCode:   -1,
+		LogEntries: entries,
+		err:        err,
+	}
+
+	var exiterr *exec.ExitError
+	if errors.As(err, &exiterr) {
+		result.ExitCode = exiterr.ExitCode()
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Level != "fatal" && entry.Level != "error" {
+			continue
+		}
+
+		if result.Message == "" {
+			result.Message = entry.Msg
+		}
+		for _, errorkind := range limactlErrorKinds {
+			if errorkind.pattern.MatchString(entry.Msg) {
+				result.kind = errorkind.kind
+				return result
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,121 @@
+package driverlima
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLimactlError(t *testing.T) {
+	tests := []struct {
+		entries         []LogEntry
+		expectedkind    error
+		expectedmessage string
+	}{
+		{
+			[]LogEntry{{Level: "fatal", Msg: "instance \"c-m\" does not exist, run `limactl create --name=c-m` to create a new instance"}},
+			ErrInstanceNotFound,
+			"instance \"c-m\" does not exist, run `limactl create --name=c-m` to create a new instance",
+		},
+		{
+			[]LogEntry{
+				{Level: "info", Msg: "Creating an instance \"c-m\""},
+				{Level: "fatal", Msg: "instance \"c-m\" already exists (/home/u/.lima/c-m)"},
+			},
+			ErrInstanceExists,
+			"instance \"c-m\" already exists (/home/u/.lima/c-m)",
+		},
+		{
+			[]LogEntry{{Level: "fatal", Msg: "instance \"c-m\" status is not \"Running\""}},
+			ErrInstanceNotRunning,
+			"instance \"c-m\" status is not \"Running\"",
+		},
+		{
+			[]LogEntry{
+				{Level: "error", Msg: "listen tcp 127.0.0.1:6443: bind: address already in use"},
+				{Level: "fatal", Msg: "degraded, status={Running:true Degraded:true}"},
+			},
+			ErrPortInUse,
+			"degraded, status={Running:true Degraded:true}",
+		},
+		{
+			[]LogEntry{{Level: "fatal", Msg: "networks.yaml: network \"kutti-c\" is not defined"}},
+			nil,
+			"networks.yaml: network \"kutti-c\" is not defined",
+		},
+		{
+			[]LogEntry{{Level: "warning", Msg: "instance \"c-m\" does not exist"}},
+			nil,
+			"",
+		},
+	}
+
+	kinds := []error{ErrInstanceNotFound, ErrInstanceExists, ErrInstanceNotRunning, ErrPortInUse}
+	for _, test := range tests {
+		err := newlimactlerror("start", errors.New("exit status 1"), test.entries)
+		if err.Message != test.expectedmessage {
+			t.Errorf("expected message %q, got %q", test.expectedmessage, err.Message)
+		}
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.expectedkind) {
+				t.Errorf("%v: expected errors.Is(err, %v) to be %v", err, kind, kind == test.expectedkind)
+			}
+		}
+	}
+}
+
+func TestLimactlErrors(t *testing.T) {
+	d := testdriver(t)
+
+	testmachine(t, d, "errors1")
+	_, err := d.NewMachine("errors1", "test", testK8sVersion)
+	if !errors.Is(err, ErrInstanceExists) {
+		t.Errorf("expected ErrInstanceExists creating an existing machine, got %v", err)
+	}
+	var limactlerr *LimactlError
+	if !errors.As(err, &limactlerr) {
+		t.Fatalf("expected a *LimactlError, got %T", err)
+	}
+	if limactlerr.Command != "create" || limactlerr.ExitCode != 1 || len(limactlerr.LogEntries) == 0 {
+		t.Errorf("unexpected LimactlError %#v", limactlerr)
+	}
+	var exiterr *exec.ExitError
+	if !errors.As(err, &exiterr) {
+		t.Error("expected LimactlError to wrap an *exec.ExitError")
+	}
+
+	missing := &Machine{driver: d, name: "errors2", clustername: "test"}
+	err = missing.Start()
+	if !errors.Is(err, ErrInstanceNotFound) {
+		t.Errorf("expected ErrInstanceNotFound starting a missing machine, got %v", err)
+	}
+
+	stopped := &Machine{driver: d, name: "errors1", clustername: "test"}
+	_, err = stopped.Exec(context.Background(), []string{"true"}, nil)
+	if !errors.Is(err, ErrInstanceNotRunning) {
+		t.Errorf("expected ErrInstanceNotRunning running a command in a stopped machine, got %v", err)
+	}
+
+	notinstalled := &Driver{
+		limactlpath: filepath.Join(t.TempDir(), "limactl"),
+		platform:    d.platform,
+		validated:   true,
+	}
+	_, err = notinstalled.runwithresults(context.Background(), "list")
+	if !errors.Is(err, ErrLimactlNotFound) {
+		t.Errorf("expected ErrLimactlNotFound running a missing limactl, got %v", err)
+	}
+}
+
+func TestErrorMatching(t *testing.T) {
+	if !errors.Is(&PortConflictError{HostPort: 8080}, ErrPortInUse) {
+		t.Error("expected PortConflictError to match ErrPortInUse")
+	}
+
+	err := &TimeoutError{Operation: "start"}
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("expected TimeoutError to match ErrTimeout and context.DeadlineExceeded")
+	}
+}
@@ -0,0 +1,48 @@
+package driverlima
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// The operating system and architecture of the host. These are variables
+// so that tests can pretend to be on a different host.
+var (
+	hostGOOS   = runtime.GOOS
+	hostGOARCH = runtime.GOARCH
+)
+
+// limaArchs maps Go architecture names to lima architecture names.
+var limaArchs = map[string]string{
+	"arm64": "aarch64",
+	"amd64": "x86_64",
+}
+
+// limaVMTypes maps Go operating system names to the lima vmType used on
+// that operating system.
+var limaVMTypes = map[string]string{
+	"darwin": "vz",
+	"linux":  "qemu",
+}
+
+// hostPlatform describes how lima VMs are run on the host.
+type hostPlatform struct {
+	vmType string
+	arch   string
+}
+
+// detecthost returns the lima vmType and architecture for the host, or
+// an error if lima VMs cannot be run by this driver on the host.
+func detecthost() (hostPlatform, error) {
+	vmtype, ok := limaVMTypes[hostGOOS]
+	if !ok {
+		return hostPlatform{}, fmt.Errorf("the lima driver does not support %v hosts", hostGOOS)
+	}
+
+	arch, ok := limaArchs[hostGOARCH]
+	if !ok {
+		return hostPlatform{}, fmt.Errorf("the lima driver does not support %v hosts", hostGOARCH)
+	}
+
+	return hostPlatform{vmType: vmtype, arch: arch}, nil
+}
@@ -0,0 +1,79 @@
+package driverlima
+
+import (
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// hostmemorybytes returns the total memory of the host, or 0 if it cannot
+// be determined.
+func hostmemorybytes() uint64 {
+	output, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		return 0
+	}
+
+	result, err := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return result
+}
+
+// hostroutetable returns the networks in the host's IPv4 routing table,
+// other than the default route and host routes.
+func hostroutetable() []*net.IPNet {
+	output, err := exec.Command("netstat", "-rn", "-f", "inet").Output()
+	if err != nil {
+		return nil
+	}
+
+	result := []*net.IPNet{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if subnet := parsenetstatdestination(fields[0]); subnet != nil {
+			result = append(result, subnet)
+		}
+	}
+
+	return result
+}
+
+// parsenetstatdestination parses a destination as shown by netstat, which
+// omits trailing zero octets and sometimes the prefix length, as in
+// "10/8" or "192.168.64". It returns nil for anything else.
+func parsenetstatdestination(destination string) *net.IPNet {
+	address, prefix, hasprefix := strings.Cut(destination, "/")
+	octets := strings.Split(address, ".")
+	if len(octets) > 4 || (len(octets) == 4 && !hasprefix) {
+		return nil
+	}
+
+	bits := 8 * len(octets)
+	if hasprefix {
+		var err error
+		bits, err = strconv.Atoi(prefix)
+		if err != nil || bits < 1 || bits > 32 {
+			return nil
+		}
+	}
+
+	for len(octets) < 4 {
+		octets = append(octets, "0")
+	}
+
+	ip := net.ParseIP(strings.Join(octets, ".")).To4()
+	if ip == nil || ip.IsLoopback() {
+		return nil
+	}
+
+	mask := net.CIDRMask(bits, 32)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
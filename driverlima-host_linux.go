@@ -0,0 +1,71 @@
+package driverlima
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// hostmemorybytes returns the total memory of the host, or 0 if it cannot
+// be determined.
+func hostmemorybytes() uint64 {
+	meminfo, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer meminfo.Close()
+
+	scanner := bufio.NewScanner(meminfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+
+	return 0
+}
+
+// hostroutetable returns the networks in the host's IPv4 routing table,
+// other than the default route.
+func hostroutetable() []*net.IPNet {
+	routes, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil
+	}
+	defer routes.Close()
+
+	result := []*net.IPNet{}
+	scanner := bufio.NewScanner(routes)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// Fields are Iface, Destination, Gateway, Flags, RefCnt, Use,
+		// Metric, Mask... with addresses in host byte order hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+
+		destination, err1 := strconv.ParseUint(fields[1], 16, 32)
+		mask, err2 := strconv.ParseUint(fields[7], 16, 32)
+		if err1 != nil || err2 != nil || mask == 0 {
+			continue
+		}
+
+		ip := make(net.IP, 4)
+		binary.LittleEndian.PutUint32(ip, uint32(destination))
+		ipmask := make(net.IPMask, 4)
+		binary.LittleEndian.PutUint32(ipmask, uint32(mask))
+
+		result = append(result, &net.IPNet{IP: ip, Mask: ipmask})
+	}
+
+	return result
+}
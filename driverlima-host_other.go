@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package driverlima
+
+import "net"
+
+// hostmemorybytes returns 0, because the total memory of the host cannot
+// be determined on this platform.
+func hostmemorybytes() uint64 {
+	return 0
+}
+
+// hostroutetable returns nil, because the routing table cannot be read on
+// this platform. Addresses of the host's interfaces are still checked.
+func hostroutetable() []*net.IPNet {
+	return nil
+}
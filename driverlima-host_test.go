@@ -0,0 +1,72 @@
+package driverlima
+
+import (
+	"testing"
+)
+
+// pretendhost makes the driver believe it is running on a different host
+// for the duration of a test.
+func pretendhost(t *testing.T, goos string, goarch string) {
+	t.Helper()
+
+	oldgoos, oldgoarch := hostGOOS, hostGOARCH
+	hostGOOS, hostGOARCH = goos, goarch
+	t.Cleanup(func() {
+		hostGOOS, hostGOARCH = oldgoos, oldgoarch
+	})
+}
+
+func TestDetectHost(t *testing.T) {
+	tests := []struct {
+		goos, goarch string
+		expected     hostPlatform
+		wanterr      bool
+	}{
+		{"darwin", "arm64", hostPlatform{vmType: "vz", arch: "aarch64"}, false},
+		{"darwin", "amd64", hostPlatform{vmType: "vz", arch: "x86_64"}, false},
+		{"linux", "amd64", hostPlatform{vmType: "qemu", arch: "x86_64"}, false},
+		{"linux", "arm64", hostPlatform{vmType: "qemu", arch: "aarch64"}, false},
+		{"windows", "amd64", hostPlatform{}, true},
+		{"linux", "386", hostPlatform{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.goos+"/"+test.goarch, func(t *testing.T) {
+			pretendhost(t, test.goos, test.goarch)
+
+			platform, err := detecthost()
+			if test.wanterr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", platform)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if platform != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, platform)
+			}
+		})
+	}
+}
+
+func TestDriverValidateUnsupportedHost(t *testing.T) {
+	pretendhost(t, "windows", "amd64")
+
+	d := &Driver{}
+	if d.Status() != "Error" || d.Error() == "" {
+		t.Errorf("expected driver error on unsupported host, got status %q", d.Status())
+	}
+}
+
+func hostplatform(t *testing.T) hostPlatform {
+	t.Helper()
+
+	platform, err := detecthost()
+	if err != nil {
+		t.Skipf("host not supported: %v", err)
+	}
+
+	return platform
+}
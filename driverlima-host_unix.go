@@ -0,0 +1,17 @@
+//go:build unix
+
+package driverlima
+
+import "syscall"
+
+// hostdiskbytes returns the size of the filesystem containing path, or 0
+// if it cannot be determined.
+func hostdiskbytes(path string) uint64 {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(existingparent(path), &stat)
+	if err != nil {
+		return 0
+	}
+
+	return stat.Blocks * uint64(stat.Bsize)
+}
@@ -0,0 +1,7 @@
+package driverlima
+
+// hostdiskbytes returns 0, because the size of the filesystem cannot be
+// determined on this platform.
+func hostdiskbytes(path string) uint64 {
+	return 0
+}
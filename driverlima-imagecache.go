@@ -0,0 +1,355 @@
+package driverlima
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// Lima caches downloaded images in a directory per URL, named after the
+// sha256 of the URL. The directory contains the downloaded file, the URL,
+// and optionally the digest, Last-Modified time and content type. Files
+// placed there by the driver are used by lima as if it had downloaded
+// them itself.
+const (
+	cacheDataFile    = "data"
+	cacheURLFile     = "url"
+	cacheTimeFile    = "time"
+	cacheTypeFile    = "type"
+	cachePartialFile = "data.kutti-partial"
+)
+
+// limaCacheDir returns the directory where lima caches downloads. Like
+// limactl, it honours the LIMA_CACHE_HOME environment variable.
+func limaCacheDir() (string, error) {
+	cachehome := os.Getenv("LIMA_CACHE_HOME")
+	if cachehome != "" {
+		return cachehome, nil
+	}
+
+	usercachedir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(usercachedir, "lima"), nil
+}
+
+// imagecachedir returns the lima cache directory for a URL.
+func imagecachedir(url string) (string, error) {
+	cachedir, err := limaCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	urlsum := sha256.Sum256([]byte(url))
+	return filepath.Join(cachedir, "download", "by-url-sha256", hex.EncodeToString(urlsum[:])), nil
+}
+
+// cachedigestfile returns the name of the file in which lima stores a
+// digest, such as "sha256.digest".
+func cachedigestfile(digest string) string {
+	algorithm, _, _ := strings.Cut(digest, ":")
+	return algorithm + ".digest"
+}
+
+// downloadtocache downloads a URL into the lima cache, verifying it
+// against digest if specified. An interrupted download is resumed if the
+// server supports it. Progress is reported in bytes.
+func downloadtocache(url string, digest string, progress func(current int64, total int64)) error {
+	if digest != "" {
+		err := validdigest(digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	cachedir, err := imagecachedir(url)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		return err
+	}
+
+	partialpath := filepath.Join(cachedir, cachePartialFile)
+	resp, offset, err := resumedownload(url, partialpath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+		kuttilog.Printf(kuttilog.Info, "Resuming download at %v bytes.", offset)
+	}
+
+	partialfile, err := os.OpenFile(partialpath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	writer := &progresswriter{
+		current:  offset,
+		total:    total,
+		progress: progress,
+	}
+
+	_, err = io.Copy(io.MultiWriter(partialfile, writer), resp.Body)
+	closeerr := partialfile.Close()
+	if err != nil {
+		// The partial file is kept, so that the download can be resumed
+		return fmt.Errorf("download of %v interrupted: %w", url, err)
+	}
+	if closeerr != nil {
+		return closeerr
+	}
+
+	if digest != "" {
+		err = verifyfiledigest(partialpath, digest)
+		if err != nil {
+			os.Remove(partialpath)
+			return err
+		}
+	}
+
+	err = removecachemetadata(cachedir)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(partialpath, filepath.Join(cachedir, cacheDataFile))
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]string{
+		cacheURLFile:  url,
+		cacheTimeFile: resp.Header.Get("Last-Modified"),
+		cacheTypeFile: resp.Header.Get("Content-Type"),
+	}
+	if digest != "" {
+		metadata[cachedigestfile(digest)] = digest
+	}
+
+	for filename, value := range metadata {
+		if value == "" {
+			continue
+		}
+		err = os.WriteFile(filepath.Join(cachedir, filename), []byte(value), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removecachemetadata removes the digests, time and type recorded for a
+// file previously in a cache directory, since they may not describe the
+// file that replaces it.
+func removecachemetadata(cachedir string) error {
+	stale, err := filepath.Glob(filepath.Join(cachedir, "*.digest"))
+	if err != nil {
+		return err
+	}
+	stale = append(stale, filepath.Join(cachedir, cacheTimeFile), filepath.Join(cachedir, cacheTypeFile))
+
+	for _, path := range stale {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resumedownload starts downloading a URL. If a partially downloaded
+// file exists, it asks the server for the remaining bytes. It returns the
+// response, and the offset in the file from which the response body
+// should be written.
+func resumedownload(url string, partialpath string) (*http.Response, int64, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := int64(0)
+	if info, err := os.Stat(partialpath); err == nil && info.Size() > 0 {
+		offset = info.Size()
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		return resp, offset, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is unusable; start again
+		resp.Body.Close()
+		os.Remove(partialpath)
+		return resumedownload(url, partialpath)
+	case resp.StatusCode == http.StatusOK:
+		return resp, 0, nil
+	}
+
+	resp.Body.Close()
+	return nil, 0, fmt.Errorf("could not download %v: %v", url, resp.Status)
+}
+
+// progresswriter reports the number of bytes written to it.
+type progresswriter struct {
+	current  int64
+	total    int64
+	progress func(current int64, total int64)
+}
+
+func (pw *progresswriter) Write(p []byte) (int, error) {
+	pw.current += int64(len(p))
+	if pw.progress != nil {
+		pw.progress(pw.current, pw.total)
+	}
+	return len(p), nil
+}
+
+// iscached checks whether lima's cache contains a URL. If digest is
+// specified, the cached file must match it. Like lima, this trusts a
+// cached digest file if present, and verifies the cached file otherwise.
+func iscached(url string, digest string) (bool, error) {
+	cachedir, err := imagecachedir(url)
+	if err != nil {
+		return false, err
+	}
+
+	datapath := filepath.Join(cachedir, cacheDataFile)
+	_, err = os.Stat(datapath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if digest == "" {
+		return true, nil
+	}
+
+	digestpath := filepath.Join(cachedir, cachedigestfile(digest))
+	cacheddigest, err := os.ReadFile(digestpath)
+	if err == nil {
+		return strings.TrimSpace(string(cacheddigest)) == digest, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	err = verifyfiledigest(datapath, digest)
+	if err != nil {
+		kuttilog.Printf(kuttilog.Verbose, "Cached image not usable: %v", err)
+		return false, nil
+	}
+
+	// Record the digest, so that it need not be computed again
+	os.WriteFile(digestpath, []byte(digest), 0644)
+	return true, nil
+}
+
+// removefromcache removes a URL from lima's cache.
+func removefromcache(url string) error {
+	cachedir, err := imagecachedir(url)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(cachedir)
+}
+
+// importtocache copies a local file into lima's cache, as if it had been
+// downloaded from a URL. If digest is specified, the file must match it.
+func importtocache(sourcepath string, url string, digest string) error {
+	if digest != "" {
+		err := verifyfiledigest(sourcepath, digest)
+		if err != nil {
+			return err
+		}
+	}
+
+	cachedir, err := imagecachedir(url)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		return err
+	}
+
+	partialpath := filepath.Join(cachedir, cachePartialFile)
+	err = copyfile(sourcepath, partialpath)
+	if err != nil {
+		os.Remove(partialpath)
+		return err
+	}
+
+	err = removecachemetadata(cachedir)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(partialpath, filepath.Join(cachedir, cacheDataFile))
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(cachedir, cacheURLFile), []byte(url), 0644)
+	if err != nil {
+		return err
+	}
+
+	if digest != "" {
+		return os.WriteFile(filepath.Join(cachedir, cachedigestfile(digest)), []byte(digest), 0644)
+	}
+
+	return nil
+}
+
+func copyfile(src string, dest string) error {
+	srcfile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcfile.Close()
+
+	destfile, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destfile, srcfile)
+	closeerr := destfile.Close()
+	if err != nil {
+		return err
+	}
+	return closeerr
+}
@@ -27,6 +27,9 @@ func (icd *imageconfigdata) Deserialize(data []byte) error {
 	loaddata := make(map[string]*Image)
 	err := json.Unmarshal(data, &loaddata)
 	if err == nil {
+		for _, image := range loaddata {
+			image.upgrade()
+		}
 		icd.images = loaddata
 	}
 	return err
@@ -40,6 +43,17 @@ func defaultimages() map[string]*Image {
 	return map[string]*Image{}
 }
 
+// hostimages returns the images that can run on the host.
+func hostimages() map[string]*Image {
+	result := map[string]*Image{}
+	for key, image := range imagedata.images {
+		if image.hostfile() != nil {
+			result[key] = image
+		}
+	}
+	return result
+}
+
 func limaConfigDir() (string, error) {
 	return workspace.ConfigDir()
 }
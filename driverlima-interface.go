@@ -1,11 +1,10 @@
 package driverlima
 
 import (
-	"bufio"
-	_ "embed"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,20 +19,41 @@ type limaInfo struct {
 	Hostname      string `json:"hostname"`
 	Status        string `json:"status"`
 	Dir           string `json:"dir"`
+	CPUs          int    `json:"cpus"`
+	Memory        int64  `json:"memory"`
+	Disk          int64  `json:"disk"`
 	SSHLocalPort  int    `json:"sshLocalPort"`
 	SSHConfigFile string `json:"sshConfigFile"`
+
+	Config *limaInstanceConfig `json:"config,omitempty"`
+}
+
+// limaInstanceConfig is the part of an instance's configuration, as
+// reported by limactl list, that the driver uses.
+type limaInstanceConfig struct {
+	SSH struct {
+		LocalPort int `json:"localPort"`
+	} `json:"ssh"`
+	PortForwards []manifestForward `json:"portForwards"`
 }
 
-type logEntry struct {
+// LogEntry is a JSON log entry written by limactl.
+type LogEntry struct {
 	Level string `json:"level"`
 	Msg   string `json:"msg"`
 	Time  string `json:"time"`
 }
 
+// limaResult is the parsed output of a limactl command. Standard output
+// and standard error are kept separately, along with their raw text.
 type limaResult struct {
-	logEntries    []logEntry
-	machineInfos  []limaInfo
+	logEntries   []LogEntry
+	machineInfos []limaInfo
+	// rawResult contains the lines of standard output that are neither
+	// log entries nor instances.
 	rawResult     string
+	stdout        string
+	stderr        string
 	isRaw         bool
 	isLogEntry    bool
 	isMachineInfo bool
@@ -56,117 +76,133 @@ func (lr *limaResult) LastLogErrorMessage() string {
 	return ""
 }
 
-// ParseLogString reads a multi-line string, parsing each line as either
-// a logEntry or a limaInfo JSON object. It returns two slices
-// containing the parsed objects of each kind, and an error if any
-// line fails to parse or doesn't strictly match one of the two expected shapes.
-func newLimaResult(input string) (*limaResult, error) {
-	// Initialize result to store the parsed objects
-	result := &limaResult{
-		logEntries:   []logEntry{},
-		machineInfos: []limaInfo{},
+// instance returns the instance with the specified name, or nil.
+func (lr *limaResult) instance(name string) *limaInfo {
+	for i := range lr.machineInfos {
+		if lr.machineInfos[i].Name == name {
+			return &lr.machineInfos[i]
+		}
 	}
-	// Initialize slices to store the parsed objects
-	// var logEntries []logEntry
-	// var machineInfos []limaInfo
+	return nil
+}
 
-	// Create a new scanner to read the input string line by line
-	scanner := bufio.NewScanner(strings.NewReader(input))
-	lineNumber := 0 // Keep track of the current line number for error reporting
+// The kinds of line written by limactl.
+const (
+	limaLineRaw = iota
+	limaLineLogEntry
+	limaLineInstance
+)
 
-	// Iterate over each line in the input string
-	for scanner.Scan() {
-		lineNumber++           // Increment line number for each new line
-		line := scanner.Text() // Get the current line content as a string
+// classifylimaline decodes a line written by limactl. A JSON object with
+// "level" and "msg" keys is a log entry, and one with "name" and "status"
+// keys is an instance from limactl list. Other keys are ignored, and so
+// are values of unexpected types, so that fields added, dropped or
+// changed by other lima versions do not matter. Anything else is raw
+// text.
+func classifylimaline(line string) (int, *LogEntry, *limaInfo) {
+	var keys map[string]json.RawMessage
+	err := json.Unmarshal([]byte(line), &keys)
+	if err != nil {
+		return limaLineRaw, nil, nil
+	}
 
-		// Skip empty lines to avoid parsing errors
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+	_, haslevel := keys["level"]
+	_, hasmsg := keys["msg"]
+	_, hasname := keys["name"]
+	_, hasstatus := keys["status"]
 
-		// First, try to unmarshal the line into a generic map to inspect its structure (keys)
-		var rawMap map[string]any
-		if err := json.Unmarshal([]byte(line), &rawMap); err != nil {
-			// If unmarshaling to a map fails, it's not a valid JSON.
-			// If no JSON lines have been read so far, return the
-			// full input string as a raw result.
-			if !result.isLogEntry && !result.isMachineInfo {
-				result.isRaw = true
-				result.rawResult = input
-				return result, nil
-			}
-
-			//return nil, fmt.Errorf("line %d: invalid JSON format: %w", lineNumber, err)
-			// Otherwise, add the line to result.rawresult. No error.
-			result.rawResult += line + "\n"
+	switch {
+	case haslevel && hasmsg:
+		var entry LogEntry
+		if tolerantunmarshal(line, &entry) && entry.Level != "" {
+			return limaLineLogEntry, &entry, nil
 		}
-
-		// Define the expected keys for each shape for strict matching
-		expectedLogEntryKeys := map[string]bool{
-			"level": true,
-			"msg":   true,
-			"time":  true,
-		}
-		expectedLimaInfoKeys := map[string]bool{
-			"name":          true,
-			"hostname":      true,
-			"status":        true,
-			"dir":           true,
-			"sshConfigFile": true,
+	case hasname && hasstatus:
+		var info limaInfo
+		if tolerantunmarshal(line, &info) && info.Name != "" {
+			return limaLineInstance, nil, &info
 		}
+	}
 
-		// Check if the current line's JSON map matches the LogEntry shape
-		isLogEntryShape := true
-		for key := range expectedLogEntryKeys { // Check if all keys match
-			if _, ok := rawMap[key]; !ok {
-				isLogEntryShape = false
-				break
-			}
-		}
+	return limaLineRaw, nil, nil
+}
 
-		// Check if the current line's JSON map matches the MachineInfo shape
-		isMachineInfoShape := true
-		for key := range expectedLimaInfoKeys { // Check if all keys match
-			if _, ok := rawMap[key]; !ok {
-				isMachineInfoShape = false
-				break
-			}
+// tolerantunmarshal decodes JSON into v, skipping values whose types do
+// not match. It returns false if the JSON could not be decoded at all.
+func tolerantunmarshal(data string, v any) bool {
+	err := json.Unmarshal([]byte(data), v)
+	var typeerr *json.UnmarshalTypeError
+	return err == nil || errors.As(err, &typeerr)
+}
+
+// newLimaResult parses the standard output and standard error of a
+// limactl command, line by line. Instances are only expected on standard
+// output. Lines that cannot be classified are kept as raw text.
+func newLimaResult(stdout string, stderr string) *limaResult {
+	result := &limaResult{
+		logEntries:   []LogEntry{},
+		machineInfos: []limaInfo{},
+		stdout:       stdout,
+		stderr:       stderr,
+	}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
 		}
 
-		// Determine which type the line matches and unmarshal accordingly
-		if isLogEntryShape && !isMachineInfoShape {
-			// It matches LogEntry shape exclusively
-			var le logEntry
-			if err := json.Unmarshal([]byte(line), &le); err != nil {
-				// This error should ideally not happen if rawMap unmarshal was successful,
-				// but it's a good safeguard.
-				return nil, fmt.Errorf("line %d: failed to unmarshal into LogEntry: %w", lineNumber, err)
-			}
-			result.logEntries = append(result.logEntries, le)
+		kind, entry, info := classifylimaline(line)
+		switch kind {
+		case limaLineLogEntry:
+			result.logEntries = append(result.logEntries, *entry)
 			result.isLogEntry = true
-		} else if isMachineInfoShape && !isLogEntryShape {
-			// It matches MachineInfo shape exclusively
-			var mi limaInfo
-			if err := json.Unmarshal([]byte(line), &mi); err != nil {
-				// Similar safeguard as above for MachineInfo
-				return nil, fmt.Errorf("line %d: failed to unmarshal into MachineInfo: %w", lineNumber, err)
-			}
-			result.machineInfos = append(result.machineInfos, mi)
+		case limaLineInstance:
+			result.machineInfos = append(result.machineInfos, *info)
 			result.isMachineInfo = true
-		} else {
-			// The line does not strictly match either shape, or it somehow matches both
-			// (which is unlikely given distinct key sets, but handled for robustness).
-			return nil, fmt.Errorf("line %d: does not strictly match either expected JSON shape. Content: %s", lineNumber, line)
+		default:
+			result.rawResult += line + "\n"
+			result.isRaw = true
 		}
 	}
 
-	// Check for any errors that occurred during scanning (e.g., I/O errors)
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading input string: %w", err)
+	for _, line := range strings.Split(stderr, "\n") {
+		kind, entry, _ := classifylimaline(strings.TrimRight(line, "\r"))
+		if kind == limaLineLogEntry {
+			result.logEntries = append(result.logEntries, *entry)
+			result.isLogEntry = true
+		}
 	}
 
-	// Return the result and no error
-	return result, nil
+	return result
+}
+
+// limaHomeDir returns the directory where lima keeps instances and
+// configuration. Like limactl, it honours the LIMA_HOME environment variable.
+func limaHomeDir() (string, error) {
+	limahome := os.Getenv("LIMA_HOME")
+	if limahome != "" {
+		return limahome, nil
+	}
+
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homedir, ".lima"), nil
+}
+
+// existingparent returns path, or its nearest ancestor that exists.
+func existingparent(path string) string {
+	for {
+		_, err := os.Stat(path)
+		parent := filepath.Dir(path)
+		if err == nil || parent == path {
+			return path
+		}
+		path = parent
+	}
 }
 
 func machineDir() (string, error) {
@@ -190,7 +226,7 @@ func findLimaCtl() (string, error) {
 		return toolpath, nil
 	}
 
-	return "", errors.New("limactl not found")
+	return "", ErrLimactlNotFound
 }
 
 func (d *Driver) validate() error {
@@ -198,6 +234,13 @@ func (d *Driver) validate() error {
 		return nil
 	}
 
+	platform, err := detecthost()
+	if err != nil {
+		d.status = "Error"
+		d.errormessage = err.Error()
+		return err
+	}
+
 	limactlpath, err := findLimaCtl()
 	if err != nil {
 		d.status = "Error"
@@ -206,6 +249,7 @@ func (d *Driver) validate() error {
 	}
 
 	d.limactlpath = limactlpath
+	d.platform = platform
 	d.status = "Ready"
 	d.errormessage = ""
 	d.validated = true
@@ -213,7 +257,10 @@ func (d *Driver) validate() error {
 	return nil
 }
 
-func (d *Driver) runwithresults(args ...string) (*limaResult, error) {
+// limactlargs prefixes the arguments for a limactl command with global
+// flags, which make limactl non-interactive and log in JSON at the level
+// matching kuttilog's.
+func limactlargs(args ...string) []string {
 	limactlargs := []string{
 		"--tty=false",
 		"--log-format=json",
@@ -231,31 +278,28 @@ func (d *Driver) runwithresults(args ...string) (*limaResult, error) {
 	case kuttilog.Debug:
 		limactlargs = append(limactlargs, "--log-level", "debug")
 	}
-	limactlargs = append(limactlargs, args...)
-	resultstring, err := workspace.RunWithResults(d.limactlpath, limactlargs...)
-	result, err2 := newLimaResult(resultstring)
-	if err2 != nil {
-		err = errors.Join(err, err2)
-	}
-	return result, err
+	return append(limactlargs, args...)
 }
 
-//go:embed assets/knode.yaml
-var manifest string
-
-func writemanifest(manifestpath string, imagesourceurl string) error {
-	manifestFile, err := os.Create(manifestpath)
-	if err != nil {
-		return err
+// runwithresults runs limactl, and returns its parsed output. The log
+// entries on its standard error are forwarded to kuttilog as they
+// arrive, so that long-running commands show what they are doing.
+func (d *Driver) runwithresults(ctx context.Context, args ...string) (*limaResult, error) {
+	var output bytes.Buffer
+	stream := newlogstream(d.progress)
+	err := d.runlimactl(ctx, &limactlRun{
+		args:   args,
+		stdout: &output,
+		stderr: stream,
+	})
+	stream.Close()
+
+	result := newLimaResult(output.String(), stream.String())
+
+	var exiterr *exec.ExitError
+	if errors.As(err, &exiterr) {
+		err = newlimactlerror(args[0], err, result.logEntries)
 	}
 
-	defer manifestFile.Close()
-
-	newmanifest := strings.Replace(manifest, "{{ .ImageSourceUrl }}", imagesourceurl, 1)
-	_, err = manifestFile.WriteString(newmanifest)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return result, err
 }
@@ -0,0 +1,212 @@
+package driverlima
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The files under testdata/lima-<version> were captured from limactl
+// 0.23.2, 1.0.7 and 1.1.1 on a linux/amd64 host without QEMU, with
+// assets/knode.yaml changed to qemu and x86_64 as the template. Each
+// command was run with --tty=false and --log-level info, and with
+// --log-format=json from 1.0.7 on, as 0.23.2 does not have that flag:
+//
+//	list.stdout            limactl list --format json, after creating c-m1 and c-m2
+//	list-empty.stderr      limactl list --format json, with no instances
+//	list-nomatch.stderr    limactl list --format json c-none
+//	create.stderr          limactl create --name=c-m1 knode.yaml, failing on qemu-img
+//	create-exists.stderr   the same, run again
+//	start.stderr           limactl start c-m1, failing on qemu-img
+//	shell-stopped.stderr   limactl shell c-m1 hostname
+var limaOutputTests = []struct {
+	name              string
+	stdoutfile        string
+	stderrfile        string
+	stdout            string
+	stderr            string
+	expectedinstances []string
+	expectedlevels    []string
+	expectedraw       string
+	expectederror     string
+}{
+	{
+		name:              "list from lima 0.23.2, without hostname",
+		stdoutfile:        "lima-0.23.2/list.stdout",
+		expectedinstances: []string{"c-m1", "c-m2"},
+	},
+	{
+		name:              "list from lima 1.0.7",
+		stdoutfile:        "lima-1.0.7/list.stdout",
+		expectedinstances: []string{"c-m1", "c-m2"},
+	},
+	{
+		name:              "list from lima 1.1.1",
+		stdoutfile:        "lima-1.1.1/list.stdout",
+		expectedinstances: []string{"c-m1", "c-m2"},
+	},
+	{
+		name:       "list of no instances from lima 0.23.2, which logs text",
+		stderrfile: "lima-0.23.2/list-empty.stderr",
+	},
+	{
+		name:           "list of no instances from lima 1.0.7",
+		stderrfile:     "lima-1.0.7/list-empty.stderr",
+		expectedlevels: []string{"warning"},
+	},
+	{
+		name:           "list of an unknown instance from lima 1.1.1",
+		stderrfile:     "lima-1.1.1/list-nomatch.stderr",
+		expectedlevels: []string{"warning"},
+	},
+	{
+		name:       "create from lima 0.23.2, which logs text",
+		stderrfile: "lima-0.23.2/create.stderr",
+	},
+	{
+		name:           "create from lima 1.0.7, with extra fields and a fatal error",
+		stderrfile:     "lima-1.0.7/create.stderr",
+		expectedlevels: []string{"info", "info", "info", "fatal"},
+		expectederror:  `failed to get the information of base disk "/tmp/lima/work/home-v1.0.7/c-m1/basedisk": failed to run [qemu-img info --output=json --force-share /tmp/lima/work/home-v1.0.7/c-m1/basedisk]: stdout="", stderr="": exec: "qemu-img": executable file not found in $PATH`,
+	},
+	{
+		name:           "create of an existing instance from lima 1.0.7",
+		stderrfile:     "lima-1.0.7/create-exists.stderr",
+		expectedlevels: []string{"fatal"},
+		expectederror:  `instance "c-m1" already exists`,
+	},
+	{
+		name:           "create of an existing instance from lima 1.1.1",
+		stderrfile:     "lima-1.1.1/create-exists.stderr",
+		expectedlevels: []string{"fatal"},
+		expectederror:  `instance "c-m1" already exists`,
+	},
+	{
+		name:           "start from lima 1.1.1",
+		stderrfile:     "lima-1.1.1/start.stderr",
+		expectedlevels: []string{"info", "info", "fatal"},
+		expectederror:  `failed to get the information of base disk "/tmp/lima/work/home-v1.1.1/c-m1/basedisk": failed to run [qemu-img info --output=json --force-share /tmp/lima/work/home-v1.1.1/c-m1/basedisk]: stdout="", stderr="": exec: "qemu-img": executable file not found in $PATH`,
+	},
+	{
+		name:           "shell into a stopped instance from lima 1.0.7",
+		stderrfile:     "lima-1.0.7/shell-stopped.stderr",
+		expectedlevels: []string{"fatal"},
+		expectederror:  "instance \"c-m1\" is stopped, run `limactl start c-m1` to start the instance",
+	},
+	{
+		name:   "shell output that is not JSON, followed by an object of unknown shape",
+		stdout: "192.168.128.3\r\n{\"name\":\"x\"}\n[1,2]\n",
+		stderr: "bash: warning: setlocale: LC_ALL: cannot change locale\n" +
+			`{"level":"fatal","msg":"exit status 1","time":"2026-10-18T09:45:14Z"}` + "\n",
+		expectedlevels: []string{"fatal"},
+		expectedraw:    "192.168.128.3\n{\"name\":\"x\"}\n[1,2]\n",
+		expectederror:  "exit status 1",
+	},
+	{
+		name: "no output",
+	},
+}
+
+// readlimaoutput returns the contents of a file captured from limactl,
+// or the empty string if no file is named.
+func readlimaoutput(t *testing.T, name string) string {
+	t.Helper()
+
+	if name == "" {
+		return ""
+	}
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestNewLimaResult(t *testing.T) {
+	for _, test := range limaOutputTests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout := test.stdout + readlimaoutput(t, test.stdoutfile)
+			stderr := test.stderr + readlimaoutput(t, test.stderrfile)
+			result := newLimaResult(stdout, stderr)
+
+			var instances []string
+			for _, info := range result.machineInfos {
+				instances = append(instances, info.Name)
+			}
+			if !reflect.DeepEqual(instances, test.expectedinstances) {
+				t.Errorf("expected instances %v, got %v", test.expectedinstances, instances)
+			}
+
+			var levels []string
+			for _, entry := range result.logEntries {
+				levels = append(levels, entry.Level)
+			}
+			if !reflect.DeepEqual(levels, test.expectedlevels) {
+				t.Errorf("expected log levels %v, got %v", test.expectedlevels, levels)
+			}
+
+			if result.rawResult != test.expectedraw {
+				t.Errorf("expected raw result %q, got %q", test.expectedraw, result.rawResult)
+			}
+			if result.LastLogErrorMessage() != test.expectederror {
+				t.Errorf("expected error message %q, got %q", test.expectederror, result.LastLogErrorMessage())
+			}
+
+			if result.stdout != stdout || result.stderr != stderr {
+				t.Error("raw output not preserved")
+			}
+			if result.isMachineInfo != (len(instances) > 0) ||
+				result.isLogEntry != (len(levels) > 0) ||
+				result.isRaw != (test.expectedraw != "") {
+				t.Errorf("unexpected result flags %v, %v, %v", result.isMachineInfo, result.isLogEntry, result.isRaw)
+			}
+		})
+	}
+}
+
+func TestNewLimaResultFields(t *testing.T) {
+	tests := []struct {
+		stdoutfile       string
+		expectedhostname string
+	}{
+		{"lima-0.23.2/list.stdout", ""},
+		{"lima-1.0.7/list.stdout", "lima-c-m1"},
+		{"lima-1.1.1/list.stdout", "lima-c-m1"},
+	}
+
+	for _, test := range tests {
+		result := newLimaResult(readlimaoutput(t, test.stdoutfile), "")
+		info := result.instance("c-m1")
+		if info == nil {
+			t.Fatalf("%v: instance c-m1 not found", test.stdoutfile)
+		}
+
+		if info.Hostname != test.expectedhostname ||
+			info.Status != "Stopped" ||
+			info.CPUs != 2 ||
+			info.Memory != 2147483648 ||
+			!strings.HasSuffix(info.SSHConfigFile, "/c-m1/ssh.config") {
+			t.Errorf("%v: unexpected instance %+v", test.stdoutfile, info)
+		}
+
+		if info.Config == nil || info.Config.SSH.LocalPort != 0 {
+			t.Fatalf("%v: expected configured SSH port 0, got %+v", test.stdoutfile, info.Config)
+		}
+		forwards := info.Config.PortForwards
+		if len(forwards) != 1 || !forwards[0].Ignore || !reflect.DeepEqual(forwards[0].GuestPortRange, []int{1, 65535}) {
+			t.Errorf("%v: expected lima's default ignore rule, got %+v", test.stdoutfile, forwards)
+		}
+	}
+
+	// A field of an unexpected type is skipped, but the rest are read
+	stdout := readlimaoutput(t, "lima-1.1.1/list.stdout")
+	stdout = strings.Replace(stdout, `"memory":2147483648`, `"memory":"2GiB"`, 1)
+	result := newLimaResult(stdout, "")
+	info := result.instance("c-m1")
+	if info == nil || info.Memory != 0 || info.Status != "Stopped" || info.CPUs != 2 {
+		t.Errorf("unexpected instance %+v", info)
+	}
+}
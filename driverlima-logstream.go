@@ -0,0 +1,149 @@
+package driverlima
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// ProgressFunc receives progress reported by limactl while it runs, such
+// as the percentage of an image download during limactl create. percent
+// is between 0 and 100.
+type ProgressFunc func(message string, percent float64)
+
+// SetProgressFunc sets a function that receives progress reported by
+// limactl. It is called from the goroutine reading limactl's output.
+// A nil function stops progress reporting.
+func (vd *Driver) SetProgressFunc(progress ProgressFunc) {
+	vd.progress = progress
+}
+
+// limaLogLevels maps lima's log levels to kuttilog levels. Lima logs
+// at the level requested by limactlargs, so what it sends is shown.
+var limaLogLevels = map[string]int{
+	"panic":   kuttilog.Error,
+	"fatal":   kuttilog.Error,
+	"error":   kuttilog.Error,
+	"warning": kuttilog.Minimal,
+	"info":    kuttilog.Info,
+	"debug":   kuttilog.Verbose,
+	"trace":   kuttilog.Verbose,
+}
+
+// forwardlog prints a message from limactl. Tests replace it.
+var forwardlog = func(level int, message string) {
+	kuttilog.Println(level, message)
+}
+
+var progressPercent = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s?%`)
+
+// logStream is an io.Writer for the standard error of limactl. It
+// decodes each JSON log line as it arrives, forwards it to kuttilog,
+// and reports lines that carry progress to a ProgressFunc. It keeps
+// everything written, for parsing once limactl exits.
+type logStream struct {
+	mu       sync.Mutex
+	text     bytes.Buffer
+	partial  []byte
+	progress ProgressFunc
+}
+
+func newlogstream(progress ProgressFunc) *logStream {
+	return &logStream{progress: progress}
+}
+
+func (ls *logStream) Write(p []byte) (int, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.text.Write(p)
+	ls.partial = append(ls.partial, p...)
+	for {
+		// Progress bars redraw themselves after a carriage return, so
+		// it ends a line too
+		index := bytes.IndexAny(ls.partial, "\r\n")
+		if index < 0 {
+			break
+		}
+		ls.handleline(string(ls.partial[:index]))
+		ls.partial = ls.partial[index+1:]
+	}
+
+	return len(p), nil
+}
+
+// Close handles a final line that did not end in a newline.
+func (ls *logStream) Close() error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if len(ls.partial) > 0 {
+		ls.handleline(string(ls.partial))
+		ls.partial = nil
+	}
+	return nil
+}
+
+func (ls *logStream) handleline(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	kind, entry, _ := classifylimaline(line)
+	if kind != limaLineLogEntry {
+		line = strings.TrimSpace(line)
+		if percent := progresspercent(line); percent >= 0 {
+			ls.reportprogress(line, percent)
+			return
+		}
+		forwardlog(kuttilog.Verbose, line)
+		return
+	}
+
+	level, ok := limaLogLevels[entry.Level]
+	if !ok {
+		level = kuttilog.Info
+	}
+	// A fatal entry ends limactl, and is returned to the caller as an
+	// error. Printing it as well would report it twice.
+	if entry.Level != "fatal" {
+		forwardlog(level, entry.Msg)
+	}
+
+	if percent := progresspercent(entry.Msg); percent >= 0 {
+		ls.reportprogress(entry.Msg, percent)
+	}
+}
+
+func (ls *logStream) reportprogress(message string, percent float64) {
+	if ls.progress != nil {
+		ls.progress(message, percent)
+	}
+}
+
+// String returns everything written to the stream.
+func (ls *logStream) String() string {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return ls.text.String()
+}
+
+// progresspercent returns the last percentage in a line, or -1 if there
+// is none.
+func progresspercent(line string) float64 {
+	matches := progressPercent.FindAllStringSubmatch(line, -1)
+	if len(matches) == 0 {
+		return -1
+	}
+
+	percent, err := strconv.ParseFloat(matches[len(matches)-1][1], 64)
+	if err != nil || percent > 100 {
+		return -1
+	}
+	return percent
+}
@@ -0,0 +1,126 @@
+package driverlima
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+type forwardedlog struct {
+	level   int
+	message string
+}
+
+func recordforwardlog(t *testing.T) *[]forwardedlog {
+	var forwarded []forwardedlog
+	oldforwardlog := forwardlog
+	forwardlog = func(level int, message string) {
+		forwarded = append(forwarded, forwardedlog{level, message})
+	}
+	t.Cleanup(func() { forwardlog = oldforwardlog })
+	return &forwarded
+}
+
+func TestLogStream(t *testing.T) {
+	forwarded := recordforwardlog(t)
+
+	var progress []string
+	ls := newlogstream(func(message string, percent float64) {
+		progress = append(progress, fmt.Sprintf("%v|%v", message, percent))
+	})
+
+	output := `{"level":"info","msg":"Starting the instance","time":"2025-01-01T00:00:00Z"}
+{"level":"warning","msg":"Template locator is deprecated","time":"2025-01-01T00:00:01Z"}
+{"level":"debug","msg":"Using cached image","time":"2025-01-01T00:00:02Z"}
+1.00 MiB / 2.00 MiB [=====>_____] 50.00%` + "\r" + `2.00 MiB / 2.00 MiB [===========] 100.00%
+not a log line
+{"level":"fatal","msg":"instance \"x\" already exists","time":"2025-01-01T00:00:03Z"}
+{"level":"info","msg":"no newline at the end","time":"2025-01-01T00:00:04Z"}`
+
+	// Write in small pieces, to split lines across writes
+	for start := 0; start < len(output); start += 7 {
+		end := start + 7
+		if end > len(output) {
+			end = len(output)
+		}
+		n, err := ls.Write([]byte(output[start:end]))
+		if err != nil || n != end-start {
+			t.Fatalf("Write returned %v, %v", n, err)
+		}
+	}
+	ls.Close()
+
+	expectedforwarded := []forwardedlog{
+		{kuttilog.Info, "Starting the instance"},
+		{kuttilog.Minimal, "Template locator is deprecated"},
+		{kuttilog.Verbose, "Using cached image"},
+		{kuttilog.Verbose, "not a log line"},
+		{kuttilog.Info, "no newline at the end"},
+	}
+	if !reflect.DeepEqual(*forwarded, expectedforwarded) {
+		t.Errorf("expected forwarded log %v, got %v", expectedforwarded, *forwarded)
+	}
+
+	expectedprogress := []string{
+		"1.00 MiB / 2.00 MiB [=====>_____] 50.00%|50",
+		"2.00 MiB / 2.00 MiB [===========] 100.00%|100",
+	}
+	if !reflect.DeepEqual(progress, expectedprogress) {
+		t.Errorf("expected progress %v, got %v", expectedprogress, progress)
+	}
+
+	if ls.String() != output {
+		t.Errorf("expected stream to keep everything written, got %q", ls.String())
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected float64
+	}{
+		{"12.50% done", 12.5},
+		{"1.00 MiB / 2.00 MiB [=====>_____] 50.00% 1.2 MiB/s", 50},
+		{"Downloaded the image: 100%", 100},
+		{"from 10% to 20 %", 20},
+		{"Starting the instance", -1},
+		{"cpu usage 250%", -1},
+	}
+
+	for _, test := range tests {
+		got := progresspercent(test.line)
+		if got != test.expected {
+			t.Errorf("progresspercent(%q): expected %v, got %v", test.line, test.expected, got)
+		}
+	}
+}
+
+func TestNewMachineProgress(t *testing.T) {
+	d := testdriver(t)
+	forwarded := recordforwardlog(t)
+
+	var percents []float64
+	d.SetProgressFunc(func(message string, percent float64) {
+		percents = append(percents, percent)
+	})
+	t.Cleanup(func() { d.SetProgressFunc(nil) })
+
+	testmachine(t, d, "progress1")
+
+	expectedpercents := []float64{50, 100, 100}
+	if !reflect.DeepEqual(percents, expectedpercents) {
+		t.Errorf("expected progress %v, got %v", expectedpercents, percents)
+	}
+
+	found := false
+	for _, entry := range *forwarded {
+		if entry.level == kuttilog.Info && entry.message == "Attempting to download the image" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("limactl create log not forwarded, got %v", *forwarded)
+	}
+}
@@ -0,0 +1,333 @@
+package driverlima
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The base lima template for kutti nodes. Settings in this file that the
+// driver does not need to change are carried over unmodified.
+//
+//go:embed assets/knode.yaml
+var manifest string
+
+// limaManifest models the subset of the lima template that the driver
+// manages. All other settings in the template are preserved in Extra.
+type limaManifest struct {
+	VMType       string            `yaml:"vmType,omitempty"`
+	Arch         string            `yaml:"arch,omitempty"`
+	Images       []manifestImage   `yaml:"images"`
+	CPUs         int               `yaml:"cpus,omitempty"`
+	Memory       string            `yaml:"memory,omitempty"`
+	Disk         string            `yaml:"disk,omitempty"`
+	Mounts       []manifestMount   `yaml:"mounts"`
+	SSH          manifestSSH       `yaml:"ssh"`
+	Networks     []manifestNetwork `yaml:"networks,omitempty"`
+	PortForwards []manifestForward `yaml:"portForwards,omitempty"`
+	Provision    []manifestScript  `yaml:"provision,omitempty"`
+	Extra        map[string]any    `yaml:",inline"`
+}
+
+type manifestImage struct {
+	Location string `yaml:"location"`
+	Arch     string `yaml:"arch,omitempty"`
+	Digest   string `yaml:"digest,omitempty"`
+}
+
+type manifestMount struct {
+	Location   string         `yaml:"location"`
+	MountPoint string         `yaml:"mountPoint,omitempty"`
+	Writable   *bool          `yaml:"writable,omitempty"`
+	Extra      map[string]any `yaml:",inline"`
+}
+
+type manifestSSH struct {
+	LocalPort         int            `yaml:"localPort"`
+	LoadDotSSHPubKeys *bool          `yaml:"loadDotSSHPubKeys,omitempty"`
+	ForwardAgent      *bool          `yaml:"forwardAgent,omitempty"`
+	ForwardX11        *bool          `yaml:"forwardX11,omitempty"`
+	ForwardX11Trusted *bool          `yaml:"forwardX11Trusted,omitempty"`
+	Extra             map[string]any `yaml:",inline"`
+}
+
+type manifestNetwork struct {
+	Lima       string         `yaml:"lima,omitempty"`
+	Socket     string         `yaml:"socket,omitempty"`
+	MACAddress string         `yaml:"macAddress,omitempty"`
+	Interface  string         `yaml:"interface,omitempty"`
+	Extra      map[string]any `yaml:",inline"`
+}
+
+type manifestForward struct {
+	GuestIP        string `yaml:"guestIP,omitempty" json:"guestIP,omitempty"`
+	GuestPort      int    `yaml:"guestPort,omitempty" json:"guestPort,omitempty"`
+	GuestPortRange []int  `yaml:"guestPortRange,omitempty,flow" json:"guestPortRange,omitempty"`
+	GuestSocket    string `yaml:"guestSocket,omitempty" json:"guestSocket,omitempty"`
+	HostIP         string `yaml:"hostIP,omitempty" json:"hostIP,omitempty"`
+	HostPort       int    `yaml:"hostPort,omitempty" json:"hostPort,omitempty"`
+	HostPortRange  []int  `yaml:"hostPortRange,omitempty,flow" json:"hostPortRange,omitempty"`
+	HostSocket     string `yaml:"hostSocket,omitempty" json:"hostSocket,omitempty"`
+	Proto          string `yaml:"proto,omitempty" json:"proto,omitempty"`
+	Ignore         bool   `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+type manifestScript struct {
+	Mode   string `yaml:"mode"`
+	Script string `yaml:"script"`
+}
+
+var (
+	validVMTypes    = map[string]bool{"vz": true, "qemu": true}
+	validArchs      = map[string]bool{"aarch64": true, "x86_64": true}
+	validScriptMode = map[string]bool{"system": true, "user": true, "boot": true, "dependency": true}
+)
+
+// newmanifest returns the base kutti node manifest.
+func newmanifest() (*limaManifest, error) {
+	return parsemanifest([]byte(manifest))
+}
+
+func parsemanifest(data []byte) (*limaManifest, error) {
+	result := &limaManifest{}
+	err := yaml.Unmarshal(data, result)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse lima manifest: %w", err)
+	}
+
+	return result, nil
+}
+
+// setImage replaces the images in the manifest with a single image,
+// for the manifest's architecture.
+func (lm *limaManifest) setImage(location string, digest string) {
+	lm.Images = []manifestImage{
+		{
+			Location: location,
+			Arch:     lm.Arch,
+			Digest:   digest,
+		},
+	}
+}
+
+// setforward adds a rule forwarding a guest TCP port to a host port, or
+// changes the host port of an existing rule. Lima uses the first rule
+// that matches a port, so the rule is placed ahead of any port range
+// rules, and the ignore rule from the base manifest.
+func (lm *limaManifest) setforward(hostport int, guestport int) {
+	rule := manifestForward{
+		GuestIP:   "0.0.0.0",
+		GuestPort: guestport,
+		HostIP:    "0.0.0.0",
+		HostPort:  hostport,
+	}
+
+	position := len(lm.PortForwards)
+	for i, existing := range lm.PortForwards {
+		if existing.isportrule(guestport) {
+			lm.PortForwards[i] = rule
+			return
+		}
+		if existing.GuestPortRange != nil || existing.Ignore {
+			position = i
+			break
+		}
+	}
+
+	lm.PortForwards = append(lm.PortForwards[:position], append([]manifestForward{rule}, lm.PortForwards[position:]...)...)
+}
+
+// removeforward removes the rule forwarding a guest port added by
+// setforward. It returns false if there was no such rule.
+func (lm *limaManifest) removeforward(guestport int) bool {
+	for i, existing := range lm.PortForwards {
+		if existing.isportrule(guestport) {
+			lm.PortForwards = append(lm.PortForwards[:i], lm.PortForwards[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// isportrule returns true if the rule forwards exactly the specified
+// guest port.
+func (pf *manifestForward) isportrule(guestport int) bool {
+	return pf.GuestPort == guestport && pf.GuestPortRange == nil && !pf.Ignore
+}
+
+// portforwardsexpression returns a limactl edit expression which sets the
+// portForwards of an instance to those in the manifest.
+func (lm *limaManifest) portforwardsexpression() (string, error) {
+	value := &yaml.Node{}
+	err := value.Encode(lm.PortForwards)
+	if err != nil {
+		return "", err
+	}
+	value.Style = yaml.FlowStyle
+
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return ".portForwards = " + strings.TrimSpace(string(data)), nil
+}
+
+// validate checks the manifest for errors that would cause limactl
+// create to fail.
+func (lm *limaManifest) validate() error {
+	if !validVMTypes[lm.VMType] {
+		return fmt.Errorf("unsupported vmType '%v'", lm.VMType)
+	}
+
+	if !validArchs[lm.Arch] {
+		return fmt.Errorf("unsupported arch '%v'", lm.Arch)
+	}
+
+	if len(lm.Images) == 0 {
+		return fmt.Errorf("no images specified")
+	}
+
+	for i, image := range lm.Images {
+		if image.Location == "" {
+			return fmt.Errorf("images[%v]: location not specified", i)
+		}
+		if image.Arch != "" && !validArchs[image.Arch] {
+			return fmt.Errorf("images[%v]: unsupported arch '%v'", i, image.Arch)
+		}
+		if image.Digest != "" {
+			err := validdigest(image.Digest)
+			if err != nil {
+				return fmt.Errorf("images[%v]: %w", i, err)
+			}
+		}
+	}
+
+	if lm.CPUs < 0 {
+		return fmt.Errorf("invalid number of cpus: %v", lm.CPUs)
+	}
+
+	if _, err := parsesize(lm.Memory); lm.Memory != "" && err != nil {
+		return fmt.Errorf("invalid memory size '%v'", lm.Memory)
+	}
+
+	if _, err := parsesize(lm.Disk); lm.Disk != "" && err != nil {
+		return fmt.Errorf("invalid disk size '%v'", lm.Disk)
+	}
+
+	for i, mount := range lm.Mounts {
+		if mount.Location == "" {
+			return fmt.Errorf("mounts[%v]: location not specified", i)
+		}
+	}
+
+	err := validport(lm.SSH.LocalPort, true)
+	if err != nil {
+		return fmt.Errorf("ssh.localPort: %w", err)
+	}
+
+	for i, network := range lm.Networks {
+		if network.Lima == "" && network.Socket == "" {
+			return fmt.Errorf("networks[%v]: one of lima or socket must be specified", i)
+		}
+	}
+
+	for i, rule := range lm.PortForwards {
+		err := rule.validate()
+		if err != nil {
+			return fmt.Errorf("portForwards[%v]: %w", i, err)
+		}
+	}
+
+	for i, script := range lm.Provision {
+		if !validScriptMode[script.Mode] {
+			return fmt.Errorf("provision[%v]: unsupported mode '%v'", i, script.Mode)
+		}
+	}
+
+	return nil
+}
+
+func (pf *manifestForward) validate() error {
+	if pf.Ignore && pf.HostPort == 0 && pf.HostPortRange == nil && pf.HostSocket == "" {
+		return nil
+	}
+
+	if pf.GuestSocket != "" || pf.HostSocket != "" {
+		return nil
+	}
+
+	guestrange, err := portrange(pf.GuestPort, pf.GuestPortRange)
+	if err != nil {
+		return fmt.Errorf("guest: %w", err)
+	}
+
+	hostrange, err := portrange(pf.HostPort, pf.HostPortRange)
+	if err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+
+	if hostrange[0] != 0 && hostrange[1]-hostrange[0] != guestrange[1]-guestrange[0] {
+		return fmt.Errorf("host and guest port ranges must be of the same size")
+	}
+
+	return nil
+}
+
+func portrange(port int, portrange []int) ([2]int, error) {
+	if portrange == nil {
+		return [2]int{port, port}, validport(port, true)
+	}
+
+	if port != 0 {
+		return [2]int{}, fmt.Errorf("port and port range cannot both be specified")
+	}
+
+	if len(portrange) != 2 {
+		return [2]int{}, fmt.Errorf("port range must have exactly two elements")
+	}
+
+	for _, p := range portrange {
+		err := validport(p, false)
+		if err != nil {
+			return [2]int{}, err
+		}
+	}
+
+	if portrange[0] > portrange[1] {
+		return [2]int{}, fmt.Errorf("invalid port range %v-%v", portrange[0], portrange[1])
+	}
+
+	return [2]int{portrange[0], portrange[1]}, nil
+}
+
+func validport(port int, allowzero bool) error {
+	if port == 0 && allowzero {
+		return nil
+	}
+
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %v", port)
+	}
+
+	return nil
+}
+
+// writemanifest validates the manifest, and writes it to the specified
+// path.
+func writemanifest(manifestpath string, lm *limaManifest) error {
+	err := lm.validate()
+	if err != nil {
+		return fmt.Errorf("invalid lima manifest: %w", err)
+	}
+
+	data, err := yaml.Marshal(lm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(manifestpath, data, 0644)
+}
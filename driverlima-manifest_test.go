@@ -0,0 +1,152 @@
+package driverlima
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// withoutnulls removes null values from a parsed YAML document. Lima
+// treats a null value the same as an absent key.
+func withoutnulls(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := map[string]any{}
+		for key, item := range v {
+			if item != nil {
+				result[key] = withoutnulls(item)
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = withoutnulls(item)
+		}
+		return result
+	}
+	return value
+}
+
+func parsegeneric(t *testing.T, data []byte) any {
+	t.Helper()
+
+	var result any
+	err := yaml.Unmarshal(data, &result)
+	if err != nil {
+		t.Fatalf("could not parse YAML: %v", err)
+	}
+
+	return withoutnulls(result)
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	const imageurl = "https://example.com/kutti-k8s-1.33.qcow2"
+
+	// This is what the driver used to generate, by string replacement
+	legacy := strings.Replace(manifest, "{{ .ImageSourceUrl }}", imageurl, 1)
+	expected := parsegeneric(t, []byte(legacy))
+
+	lm, err := newmanifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm.setImage(imageurl, "")
+
+	manifestpath := filepath.Join(t.TempDir(), "knode.yaml")
+	err = writemanifest(manifestpath, lm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(manifestpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual := parsegeneric(t, data)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("generated manifest differs from knode.yaml.\nexpected: %v\nactual:   %v", expected, actual)
+	}
+
+	// Parsing the generated manifest should yield the same model
+	reparsed, err := parsemanifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lm, reparsed) {
+		t.Errorf("manifest model changed after round trip.\nexpected: %+v\nactual:   %+v", lm, reparsed)
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(lm *limaManifest)
+		wanterr string
+	}{
+		{"valid", func(lm *limaManifest) {}, ""},
+		{"vmType", func(lm *limaManifest) { lm.VMType = "hyperv" }, "vmType"},
+		{"arch", func(lm *limaManifest) { lm.Arch = "riscv64" }, "arch"},
+		{"no images", func(lm *limaManifest) { lm.Images = nil }, "no images"},
+		{"image location", func(lm *limaManifest) { lm.Images[0].Location = "" }, "images[0]"},
+		{"image digest", func(lm *limaManifest) { lm.Images[0].Digest = "md5:d41d8cd98f00b204e9800998ecf8427e" }, "images[0]"},
+		{"cpus", func(lm *limaManifest) { lm.CPUs = -1 }, "cpus"},
+		{"memory", func(lm *limaManifest) { lm.Memory = "lots" }, "memory"},
+		{"memory units", func(lm *limaManifest) { lm.Memory = "4g" }, ""},
+		{"disk", func(lm *limaManifest) { lm.Disk = "100 parsecs" }, "disk"},
+		{"ssh port", func(lm *limaManifest) { lm.SSH.LocalPort = 70000 }, "ssh.localPort"},
+		{"network", func(lm *limaManifest) { lm.Networks = []manifestNetwork{{}} }, "networks[0]"},
+		{
+			"port range size",
+			func(lm *limaManifest) {
+				lm.PortForwards = []manifestForward{{GuestPortRange: []int{30000, 32767}, HostPortRange: []int{30000, 30001}}}
+			},
+			"portForwards[0]",
+		},
+		{
+			"port and range",
+			func(lm *limaManifest) {
+				lm.PortForwards = []manifestForward{{GuestPort: 80, GuestPortRange: []int{30000, 32767}}}
+			},
+			"portForwards[0]",
+		},
+		{
+			"single port",
+			func(lm *limaManifest) {
+				lm.PortForwards = append(
+					[]manifestForward{{GuestPort: 80, HostPort: 8080}},
+					lm.PortForwards...,
+				)
+			},
+			"",
+		},
+		{"provision mode", func(lm *limaManifest) { lm.Provision = []manifestScript{{Mode: "always"}} }, "provision[0]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lm, err := newmanifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			lm.setImage("https://example.com/image.qcow2", "")
+			test.modify(lm)
+
+			err = lm.validate()
+			if test.wanterr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wanterr) {
+				t.Errorf("expected error containing %q, got %v", test.wanterr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package driverlima
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Lima defines named networks in networks.yaml, in its _config directory.
+// The driver adds and removes entries in that file for cluster networks,
+// preserving everything else in it, including comments.
+const (
+	networksConfigFile = "networks.yaml"
+	networksLockFile   = "networks.yaml.kutti-lock"
+	networksLockWait   = 10 * time.Second
+	networksLockStale  = time.Minute
+)
+
+// defaultNetworksConfig is used if lima has not yet created networks.yaml.
+// Only the networks mapping is seeded: lima fills in its other settings,
+// which depend on the host and the lima release. The user-v2 network is
+// seeded with lima's defaults, because lima stops adding it once the file
+// defines another user-v2 network, and nodes created by earlier versions
+// of the driver use it.
+const defaultNetworksConfig = `networks:
+  user-v2:
+    mode: user-v2
+    gateway: 192.168.104.1
+    netmask: 255.255.255.0
+`
+
+// limaNetwork is an entry in lima's networks.yaml.
+type limaNetwork struct {
+	Mode    string `yaml:"mode"`
+	Gateway string `yaml:"gateway,omitempty"`
+	DHCPEnd string `yaml:"dhcpEnd,omitempty"`
+	Netmask string `yaml:"netmask,omitempty"`
+}
+
+// subnet returns the subnet of the network, or nil if it does not
+// specify one.
+func (ln *limaNetwork) subnet() *net.IPNet {
+	gateway := net.ParseIP(ln.Gateway).To4()
+	netmask := net.ParseIP(ln.Netmask).To4()
+	if gateway == nil || netmask == nil {
+		return nil
+	}
+
+	mask := net.IPMask(netmask)
+	return &net.IPNet{IP: gateway.Mask(mask), Mask: mask}
+}
+
+// newusernetwork returns a user-v2 network entry for a subnet. The first
+// address in the subnet is the gateway.
+func newusernetwork(subnet *net.IPNet) (*limaNetwork, error) {
+	ip := subnet.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("%v is not an IPv4 subnet", subnet)
+	}
+
+	ones, bits := subnet.Mask.Size()
+	if bits != 32 || ones > 30 {
+		return nil, fmt.Errorf("%v is too small for a network", subnet)
+	}
+
+	gateway := make(net.IP, len(ip))
+	copy(gateway, ip.Mask(subnet.Mask))
+	gateway[3]++
+
+	return &limaNetwork{
+		Mode:    "user-v2",
+		Gateway: gateway.String(),
+		Netmask: net.IP(subnet.Mask).String(),
+	}, nil
+}
+
+// limaNetworkConfig is the parsed contents of lima's networks.yaml.
+type limaNetworkConfig struct {
+	path     string
+	document yaml.Node
+}
+
+func limaConfigPath(filename string) (string, error) {
+	limahome, err := limaHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(limahome, "_config", filename), nil
+}
+
+// withnetworkconfig loads lima's networks.yaml while holding a lock on
+// it, calls update, and saves the file if update returns true.
+func withnetworkconfig(update func(nc *limaNetworkConfig) (bool, error)) error {
+	configpath, err := limaConfigPath(networksConfigFile)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(configpath), 0755)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := locknetworkconfig(filepath.Join(filepath.Dir(configpath), networksLockFile))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	nc, err := loadnetworkconfig(configpath)
+	if err != nil {
+		return err
+	}
+
+	changed, err := update(nc)
+	if err != nil || !changed {
+		return err
+	}
+
+	return nc.save()
+}
+
+// locknetworkconfig creates a lock file, waiting for any other kutti
+// process holding it. It returns a function that removes the lock file.
+func locknetworkconfig(lockpath string) (func(), error) {
+	deadline := time.Now().Add(networksLockWait)
+	for {
+		lockfile, err := os.OpenFile(lockpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(lockfile, "%v\n", os.Getpid())
+			lockfile.Close()
+			return func() { os.Remove(lockpath) }, nil
+		}
+
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		// A lock left behind by a crashed process is removed
+		if info, err := os.Stat(lockpath); err == nil && time.Since(info.ModTime()) > networksLockStale {
+			os.Remove(lockpath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock on lima network configuration: %v", lockpath)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func loadnetworkconfig(configpath string) (*limaNetworkConfig, error) {
+	data, err := os.ReadFile(configpath)
+	if os.IsNotExist(err) {
+		data = []byte(defaultNetworksConfig)
+	} else if err != nil {
+		return nil, err
+	}
+
+	nc := &limaNetworkConfig{path: configpath}
+	err = yaml.Unmarshal(data, &nc.document)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %v: %w", configpath, err)
+	}
+
+	if nc.document.Kind == 0 {
+		// Empty file
+		err = yaml.Unmarshal([]byte(defaultNetworksConfig), &nc.document)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if nc.document.Kind != yaml.DocumentNode || nc.document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("could not parse %v: not a YAML mapping", configpath)
+	}
+
+	return nc, nil
+}
+
+// save writes the configuration to a temporary file, and then replaces
+// networks.yaml with it, so that lima never sees a partially written file.
+func (nc *limaNetworkConfig) save() error {
+	data, err := yaml.Marshal(&nc.document)
+	if err != nil {
+		return err
+	}
+
+	tempfile, err := os.CreateTemp(filepath.Dir(nc.path), networksConfigFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempfile.Name())
+
+	_, err = tempfile.Write(data)
+	closeerr := tempfile.Close()
+	if err != nil {
+		return err
+	}
+	if closeerr != nil {
+		return closeerr
+	}
+
+	return os.Rename(tempfile.Name(), nc.path)
+}
+
+// networksnode returns the mapping node of the "networks" key, creating
+// it if required.
+func (nc *limaNetworkConfig) networksnode() *yaml.Node {
+	root := nc.document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "networks" {
+			value := root.Content[i+1]
+			if value.Kind != yaml.MappingNode {
+				*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			return value
+		}
+	}
+
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	root.Content = append(
+		root.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "networks"},
+		value,
+	)
+	return value
+}
+
+// networks returns all networks defined in the configuration.
+func (nc *limaNetworkConfig) networks() (map[string]*limaNetwork, error) {
+	result := map[string]*limaNetwork{}
+	node := nc.networksnode()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		network := &limaNetwork{}
+		err := node.Content[i+1].Decode(network)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse network %v in %v: %w", node.Content[i].Value, nc.path, err)
+		}
+		result[node.Content[i].Value] = network
+	}
+	return result, nil
+}
+
+// get returns the named network, or nil.
+func (nc *limaNetworkConfig) get(name string) (*limaNetwork, error) {
+	networks, err := nc.networks()
+	if err != nil {
+		return nil, err
+	}
+	return networks[name], nil
+}
+
+// set adds or replaces the named network.
+func (nc *limaNetworkConfig) set(name string, network *limaNetwork) error {
+	value := &yaml.Node{}
+	err := value.Encode(network)
+	if err != nil {
+		return err
+	}
+
+	node := nc.networksnode()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			node.Content[i+1] = value
+			return nil
+		}
+	}
+
+	node.Content = append(
+		node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+		value,
+	)
+	return nil
+}
+
+// remove removes the named network. It returns false if the network did
+// not exist.
+func (nc *limaNetworkConfig) remove(name string) bool {
+	node := nc.networksnode()
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
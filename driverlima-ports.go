@@ -0,0 +1,115 @@
+package driverlima
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PortConflictError is returned when a Machine port cannot be forwarded
+// to a host port, because the host port is already in use.
+type PortConflictError struct {
+	HostPort int
+	// Owner describes what uses the port, if it could be determined.
+	Owner string
+}
+
+func (e *PortConflictError) Error() string {
+	if e.Owner == "" {
+		return fmt.Sprintf("host port %v is already in use", e.HostPort)
+	}
+	return fmt.Sprintf("host port %v is already in use by %v", e.HostPort, e.Owner)
+}
+
+// Is makes a PortConflictError match ErrPortInUse.
+func (e *PortConflictError) Is(target error) bool {
+	return target == ErrPortInUse
+}
+
+// checkhostport checks whether hostport can be forwarded to guestport of
+// the specified lima instance. It returns a *PortConflictError if the
+// host port is used by the SSH port or a port forwarding rule of any lima
+// instance, other than the rule being replaced, or if it cannot be bound
+// on the host. Lima only binds host ports in a range for guest ports that
+// are listening, but a range that covers the host port is still treated
+// as a conflict, since the port may be bound whenever the guest listens.
+func (vd *Driver) checkhostport(ctx context.Context, hostport int, instance string, guestport int) error {
+	result, err := vd.runwithresults(ctx, "list", "--format", "json")
+	if err != nil {
+		return fmt.Errorf("could not list lima instances: %w", err)
+	}
+
+	for _, info := range result.machineInfos {
+		owner := portowner(&info, hostport, instance, guestport)
+		if owner != "" {
+			return &PortConflictError{HostPort: hostport, Owner: owner}
+		}
+	}
+
+	if !hostportfree(hostport) {
+		return &PortConflictError{HostPort: hostport}
+	}
+
+	return nil
+}
+
+// portowner describes the use of hostport by a lima instance, or returns
+// an empty string if the instance does not use it.
+func portowner(info *limaInfo, hostport int, instance string, guestport int) string {
+	samerule := func(port int) bool {
+		return info.Name == instance && port == guestport
+	}
+
+	sshport := info.SSHLocalPort
+	if info.Config != nil && info.Config.SSH.LocalPort != 0 {
+		sshport = info.Config.SSH.LocalPort
+	}
+	if sshport == hostport && !samerule(22) {
+		return fmt.Sprintf("SSH port of lima instance %v", info.Name)
+	}
+
+	if info.Config == nil {
+		return ""
+	}
+
+	for _, rule := range info.Config.PortForwards {
+		forward, ok := rule.forwardedport()
+		if !ok || forward.Proto != "tcp" {
+			continue
+		}
+		if hostport < forward.HostPortStart || hostport > forward.HostPortEnd {
+			continue
+		}
+
+		// A rule that forwards the guest port being forwarded to the same
+		// host port is replaced by the new rule
+		port := forward.MachinePortStart + hostport - forward.HostPortStart
+		if samerule(port) {
+			continue
+		}
+
+		if forward.Kind == ForwardedPortRule {
+			return fmt.Sprintf("port %v of lima instance %v", port, info.Name)
+		}
+		return fmt.Sprintf(
+			"port range %v-%v of lima instance %v",
+			forward.MachinePortStart, forward.MachinePortEnd, info.Name,
+		)
+	}
+
+	return ""
+}
+
+// hostportfree probes whether a TCP port can be bound on the host, on
+// both the loopback and the wildcard address.
+func hostportfree(hostport int) bool {
+	for _, address := range []string{"127.0.0.1", "0.0.0.0"} {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(hostport)))
+		if err != nil {
+			return false
+		}
+		listener.Close()
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package driverlima
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func expectportconflict(t *testing.T, err error, hostport int, owner string) {
+	t.Helper()
+
+	var conflict *PortConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected *PortConflictError, got %v", err)
+	}
+	if conflict.HostPort != hostport {
+		t.Errorf("expected conflict on host port %v, got %v", hostport, conflict.HostPort)
+	}
+	if owner == "" && conflict.Owner != "" || !strings.Contains(conflict.Owner, owner) {
+		t.Errorf("expected owner %q, got %q", owner, conflict.Owner)
+	}
+}
+
+func TestPortConflicts(t *testing.T) {
+	d := testdriver(t)
+	m1 := testmachine(t, d, "port1")
+	m2 := testmachine(t, d, "port2")
+
+	err := m1.ForwardSSHPort(10122)
+	if err != nil {
+		t.Fatalf("ForwardSSHPort failed: %v", err)
+	}
+	err = m1.ForwardPort(18180, 80)
+	if err != nil {
+		t.Fatalf("ForwardPort failed: %v", err)
+	}
+
+	// A machine can keep its own ports
+	err = m1.ForwardSSHPort(10122)
+	if err != nil {
+		t.Errorf("expected no error forwarding the same SSH port again, got %v", err)
+	}
+	err = m1.ForwardPort(18180, 80)
+	if err != nil {
+		t.Errorf("expected no error forwarding the same port again, got %v", err)
+	}
+
+	// But not use the same host port twice
+	err = m1.ForwardPort(10122, 443)
+	expectportconflict(t, err, 10122, "SSH port of lima instance "+m1.qName())
+
+	err = m2.ForwardSSHPort(10122)
+	expectportconflict(t, err, 10122, "SSH port of lima instance "+m1.qName())
+
+	err = m2.ForwardSSHPort(18180)
+	expectportconflict(t, err, 18180, "port 80 of lima instance "+m1.qName())
+
+	err = m2.ForwardPort(18180, 8080)
+	expectportconflict(t, err, 18180, "port 80 of lima instance "+m1.qName())
+
+	// Ports are free again after they are unforwarded
+	err = m1.UnforwardPort(80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m2.ForwardPort(18180, 8080)
+	if err != nil {
+		t.Errorf("expected no error forwarding an unforwarded port, got %v", err)
+	}
+
+	// Port ranges of any instance, like the NodePort range that earlier
+	// versions of the driver forwarded on every node
+	_, err = d.runwithresults(
+		context.Background(),
+		"edit", m1.qName(), "--set",
+		`.portForwards = [{"guestPortRange": [30000, 32767], "hostIP": "0.0.0.0"}, {"ignore": true}]`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m2.ForwardPort(30080, 80)
+	expectportconflict(t, err, 30080, "port range 30000-32767 of lima instance "+m1.qName())
+
+	err = m1.ForwardPort(30080, 80)
+	expectportconflict(t, err, 30080, "port range 30000-32767 of lima instance "+m1.qName())
+
+	// Forwarding a port in the range to the same host port only replaces
+	// the range for that port
+	err = m1.ForwardPort(30080, 30080)
+	if err != nil {
+		t.Errorf("expected no error forwarding a port to its own host port in the range, got %v", err)
+	}
+
+	// Ports used by other processes
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	busyport := listener.Addr().(*net.TCPAddr).Port
+
+	err = m2.ForwardPort(busyport, 443)
+	expectportconflict(t, err, busyport, "")
+
+	err = m2.ForwardSSHPort(busyport)
+	expectportconflict(t, err, busyport, "")
+
+	// Lima forwards every port with a rule that specifies no guest port
+	_, err = d.runwithresults(
+		context.Background(),
+		"edit", m1.qName(), "--set",
+		`.portForwards = [{"hostIP": "0.0.0.0"}]`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m2.ForwardPort(18280, 80)
+	expectportconflict(t, err, 18280, "port range 1-65535 of lima instance "+m1.qName())
+}
+
+func TestPortConflictError(t *testing.T) {
+	err := &PortConflictError{HostPort: 2222, Owner: "SSH port of lima instance kutti-a-b"}
+	if err.Error() != "host port 2222 is already in use by SSH port of lima instance kutti-a-b" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	err = &PortConflictError{HostPort: 2222}
+	if err.Error() != "host port 2222 is already in use" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+
+package driverlima
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setprocessgroup makes a command the leader of a new process group, so
+// that it can be killed along with its children.
+func setprocessgroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptprocessgroup sends SIGINT to the process group of a command
+// started after setprocessgroup, as Ctrl-C in a terminal would.
+func interruptprocessgroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+	if err != nil {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	return nil
+}
+
+// killprocessgroup kills the process group of a command started after
+// setprocessgroup.
+func killprocessgroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// raisesignal sends a signal to this process.
+func raisesignal(sig os.Signal) {
+	if sig, ok := sig.(syscall.Signal); ok {
+		syscall.Kill(os.Getpid(), sig)
+	}
+}
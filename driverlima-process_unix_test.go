@@ -0,0 +1,229 @@
+//go:build !windows
+
+package driverlima
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunnerKillsProcessGroup(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "runner2")
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// If sleep, started by the fake limactl, survived, it would hold the
+	// output open, and the runner would wait for the full delay
+	savedwaitdelay := limactlWaitDelay
+	limactlWaitDelay = 20 * time.Second
+	t.Cleanup(func() { limactlWaitDelay = savedwaitdelay })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = sleepinmachine(ctx, t, m)
+	if err == nil {
+		t.Fatal("expected error when context times out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("runner returned %v after the timeout; child process not killed", elapsed)
+	}
+}
+
+// startwithsleep starts a test machine in the background, with the fake
+// limactl start running sleep in interrupt mode, and returns the process
+// id of sleep.
+func startwithsleep(ctx context.Context, t *testing.T, m *Machine, interrupt string) (int, <-chan error) {
+	t.Helper()
+
+	pidfile := filepath.Join(t.TempDir(), "sleep.pid")
+	t.Setenv(fakeStartSleepEnv, pidfile)
+	t.Setenv(fakeStartInterruptEnv, interrupt)
+
+	started := make(chan error, 1)
+	go func() { started <- m.StartContext(ctx) }()
+
+	sleeppid := 0
+	for deadline := time.Now().Add(30 * time.Second); sleeppid == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("fake limactl start did not run sleep")
+		}
+		data, _ := os.ReadFile(pidfile)
+		sleeppid, _ = strconv.Atoi(string(data))
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Cleanup(func() { syscall.Kill(sleeppid, syscall.SIGKILL) })
+
+	return sleeppid, started
+}
+
+func TestRunnerInterruptsBeforeKilling(t *testing.T) {
+	d := testdriver(t)
+
+	savedwaitdelay := limactlWaitDelay
+	t.Cleanup(func() { limactlWaitDelay = savedwaitdelay })
+
+	t.Run("limactl cleans up", func(t *testing.T) {
+		// limactl should not be killed before it has cleaned up
+		limactlWaitDelay = 20 * time.Second
+		m := testmachine(t, d, "interrupt2")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sleeppid, started := startwithsleep(ctx, t, m, "cleanup")
+
+		start := time.Now()
+		cancel()
+		select {
+		case err := <-started:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("expected context.Canceled, got %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("start did not return after the context was cancelled")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("start returned %v after the context was cancelled", elapsed)
+		}
+
+		_, err := os.Stat(os.Getenv(fakeStartSleepEnv) + ".interrupted")
+		if err != nil {
+			t.Errorf("limactl was not interrupted: %v", err)
+		}
+		if processalive(sleeppid) {
+			t.Error("sleep survived the cleanup")
+		}
+	})
+
+	t.Run("limactl ignores interrupt", func(t *testing.T) {
+		limactlWaitDelay = 500 * time.Millisecond
+		m := testmachine(t, d, "interrupt3")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sleeppid, started := startwithsleep(ctx, t, m, "ignore")
+
+		start := time.Now()
+		cancel()
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Fatal("start did not return after the context was cancelled")
+		}
+		if elapsed := time.Since(start); elapsed < limactlWaitDelay {
+			t.Errorf("limactl was killed after %v, before the wait delay", elapsed)
+		}
+
+		for deadline := time.Now().Add(5 * time.Second); processalive(sleeppid); {
+			if time.Now().After(deadline) {
+				t.Fatal("sleep survived the wait delay")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+}
+
+// interruptHelperEnv makes TestInterruptHelper start a machine, as kutti
+// would. It is set when TestInterruptKillsLimactl runs the test binary.
+const interruptHelperEnv = "DRIVERLIMA_INTERRUPT_HELPER"
+
+func TestInterruptHelper(t *testing.T) {
+	if os.Getenv(interruptHelperEnv) == "" {
+		t.Skip("only run by TestInterruptKillsLimactl")
+	}
+
+	d := testdriver(t)
+	m := testmachine(t, d, "interrupt1")
+	err := m.Start()
+	t.Errorf("expected Start to be interrupted, but it returned %v", err)
+}
+
+func TestInterruptKillsLimactl(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "sleep.pid")
+
+	// The helper leads its own process group, like a command run from a
+	// shell. Ctrl-C in a terminal sends SIGINT to that whole group.
+	var output bytes.Buffer
+	helper := exec.Command(os.Args[0], "-test.run=^TestInterruptHelper$")
+	helper.Env = []string{interruptHelperEnv + "=1", fakeStartSleepEnv + "=" + pidfile}
+	for _, variable := range os.Environ() {
+		// Otherwise the helper would run as the fake limactl
+		if !strings.HasPrefix(variable, fakeLimactlEnv+"=") {
+			helper.Env = append(helper.Env, variable)
+		}
+	}
+	helper.Stdout = &output
+	helper.Stderr = &output
+	helper.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err := helper.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { helper.Process.Kill() })
+
+	sleeppid := 0
+	for deadline := time.Now().Add(30 * time.Second); sleeppid == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("fake limactl start did not run sleep. Output:\n%s", output.String())
+		}
+		data, _ := os.ReadFile(pidfile)
+		sleeppid, _ = strconv.Atoi(string(data))
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Cleanup(func() { syscall.Kill(sleeppid, syscall.SIGKILL) })
+
+	err = syscall.Kill(-helper.Process.Pid, syscall.SIGINT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- helper.Wait() }()
+	select {
+	case <-waited:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("helper did not exit after SIGINT. Output:\n%s", output.String())
+	}
+	if strings.Contains(output.String(), "expected Start to be interrupted") {
+		t.Errorf("Start was not interrupted. Output:\n%s", output.String())
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); processalive(sleeppid); {
+		if time.Now().After(deadline) {
+			t.Fatal("sleep started by limactl survived SIGINT")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// processalive reports whether a process exists and has not exited. An
+// exited process may linger as a zombie until it is reaped.
+func processalive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%v/stat", pid))
+	if err != nil {
+		return !os.IsNotExist(err) || runtime.GOOS != "linux"
+	}
+	// The state follows the command name, in parentheses
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndexByte(stat, ')')+1:])
+	return len(fields) == 0 || fields[0] != "Z"
+}
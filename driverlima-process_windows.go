@@ -0,0 +1,37 @@
+package driverlima
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setprocessgroup makes a command the root of a new process group.
+func setprocessgroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killprocessgroup kills a command and all its child processes.
+func killprocessgroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// interruptprocessgroup cannot send Ctrl-C to a command on Windows without
+// sending it to this process as well, so it kills the command and its
+// child processes instead.
+func interruptprocessgroup(cmd *exec.Cmd) error {
+	return killprocessgroup(cmd)
+}
+
+// raisesignal cannot send a signal to this process on Windows. The
+// interrupted operation returns an error instead.
+func raisesignal(sig os.Signal) {}
@@ -0,0 +1,80 @@
+package driverlima
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Offsets and sizes in the qcow2 header. See
+// https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+const (
+	qcow2Magic              = "QFI\xfb"
+	qcow2V2HeaderLength     = 72
+	qcow2V3HeaderLength     = 104
+	qcow2VersionOffset      = 4
+	qcow2SizeOffset         = 24
+	qcow2HeaderLengthOffset = 100
+)
+
+// validateqcow2 checks that a file looks like a qcow2 disk image, by
+// examining its header.
+func validateqcow2(filepath string) error {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%v is not a regular file", filepath)
+	}
+
+	if info.Size() < qcow2V2HeaderLength {
+		return fmt.Errorf(
+			"%v is not a qcow2 image: it is %v bytes, smaller than a qcow2 header",
+			filepath,
+			info.Size(),
+		)
+	}
+
+	header := make([]byte, qcow2V3HeaderLength)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	header = header[:n]
+
+	if !bytes.Equal(header[:len(qcow2Magic)], []byte(qcow2Magic)) {
+		return fmt.Errorf("%v is not a qcow2 image: bad magic number %x", filepath, header[:len(qcow2Magic)])
+	}
+
+	version := binary.BigEndian.Uint32(header[qcow2VersionOffset:])
+	switch version {
+	case 2:
+	case 3:
+		if len(header) < qcow2V3HeaderLength {
+			return fmt.Errorf("%v is truncated: it is too small for a qcow2 version 3 header", filepath)
+		}
+
+		headerlength := binary.BigEndian.Uint32(header[qcow2HeaderLengthOffset:])
+		if headerlength < qcow2V3HeaderLength || int64(headerlength) > info.Size() {
+			return fmt.Errorf("%v is not a valid qcow2 image: bad header length %v", filepath, headerlength)
+		}
+	default:
+		return fmt.Errorf("%v is qcow2 version %v, which is not supported", filepath, version)
+	}
+
+	if binary.BigEndian.Uint64(header[qcow2SizeOffset:]) == 0 {
+		return fmt.Errorf("%v is not a valid qcow2 image: virtual disk size is zero", filepath)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package driverlima
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/kuttiproject/workspace"
+)
+
+const resourcesConfigFile = "limaresources.json"
+
+var (
+	resourcedata             = &resourceconfigdata{}
+	resourceconfigmanager, _ = workspace.NewFileConfigManager(resourcesConfigFile, resourcedata)
+)
+
+type resourceconfigdata struct {
+	Defaults MachineResources            `json:"defaults"`
+	Clusters map[string]MachineResources `json:"clusters"`
+	Machines map[string]MachineResources `json:"machines"`
+}
+
+func (rcd *resourceconfigdata) Serialize() ([]byte, error) {
+	return json.Marshal(rcd)
+}
+
+func (rcd *resourceconfigdata) Deserialize(data []byte) error {
+	loaddata := &resourceconfigdata{}
+	err := json.Unmarshal(data, loaddata)
+	if err == nil {
+		*rcd = *loaddata
+		rcd.ensuremaps()
+	}
+	return err
+}
+
+func (rcd *resourceconfigdata) SetDefaults() {
+	*rcd = resourceconfigdata{}
+	rcd.ensuremaps()
+}
+
+func (rcd *resourceconfigdata) ensuremaps() {
+	if rcd.Clusters == nil {
+		rcd.Clusters = map[string]MachineResources{}
+	}
+	if rcd.Machines == nil {
+		rcd.Machines = map[string]MachineResources{}
+	}
+}
+
+func setresources(resources MachineResources, update func(rcd *resourceconfigdata)) error {
+	err := resources.validate()
+	if err != nil {
+		return err
+	}
+
+	err = resourceconfigmanager.Load()
+	if err != nil {
+		return err
+	}
+
+	update(resourcedata)
+	return resourceconfigmanager.Save()
+}
+
+// configuredresources returns the resources configured for a Machine,
+// without the values from the node template.
+func (vd *Driver) configuredresources(machinename string, clustername string) (MachineResources, error) {
+	err := resourceconfigmanager.Load()
+	if err != nil {
+		return MachineResources{}, err
+	}
+
+	qname := vd.QualifiedMachineName(machinename, clustername)
+	return resourcedata.Machines[qname].
+		inherit(resourcedata.Clusters[clustername]).
+		inherit(resourcedata.Defaults), nil
+}
+
+// forgetmachineresources removes the resource settings of a Machine.
+func (vd *Driver) forgetmachineresources(machinename string, clustername string) error {
+	err := resourceconfigmanager.Load()
+	if err != nil {
+		return err
+	}
+
+	qname := vd.QualifiedMachineName(machinename, clustername)
+	if _, ok := resourcedata.Machines[qname]; !ok {
+		return nil
+	}
+
+	delete(resourcedata.Machines, qname)
+	return resourceconfigmanager.Save()
+}
+
+// inherit returns resources with zero values replaced by values from
+// parent.
+func (mr MachineResources) inherit(parent MachineResources) MachineResources {
+	if mr.CPUs == 0 {
+		mr.CPUs = parent.CPUs
+	}
+	if mr.MemoryMiB == 0 {
+		mr.MemoryMiB = parent.MemoryMiB
+	}
+	if mr.DiskGiB == 0 {
+		mr.DiskGiB = parent.DiskGiB
+	}
+	return mr
+}
+
+// validate checks the resources against the limits of the host.
+func (mr MachineResources) validate() error {
+	if mr.CPUs < 0 || mr.MemoryMiB < 0 || mr.DiskGiB < 0 {
+		return fmt.Errorf("machine resources cannot be negative")
+	}
+
+	if cpus := runtime.NumCPU(); mr.CPUs > cpus {
+		return fmt.Errorf("%v CPUs requested, but the host has only %v", mr.CPUs, cpus)
+	}
+
+	if memory := hostmemorybytes() >> 20; memory > 0 && uint64(mr.MemoryMiB) > memory {
+		return fmt.Errorf("%vMiB memory requested, but the host has only %vMiB", mr.MemoryMiB, memory)
+	}
+
+	limahome, err := limaHomeDir()
+	if err != nil {
+		return err
+	}
+
+	// Lima's disk images are sparse, and grow as they are used. So the
+	// disk size is only checked against the size of the host filesystem,
+	// not its free space.
+	if disk := hostdiskbytes(limahome) >> 30; disk > 0 && uint64(mr.DiskGiB) > disk {
+		return fmt.Errorf("%vGiB disk requested, but the host disk is only %vGiB", mr.DiskGiB, disk)
+	}
+
+	return nil
+}
+
+// applyResources sets the non-zero resource values in the manifest.
+func (lm *limaManifest) applyResources(mr MachineResources) {
+	if mr.CPUs > 0 {
+		lm.CPUs = mr.CPUs
+	}
+	if mr.MemoryMiB > 0 {
+		lm.Memory = fmt.Sprintf("%vMiB", mr.MemoryMiB)
+	}
+	if mr.DiskGiB > 0 {
+		lm.Disk = fmt.Sprintf("%vGiB", mr.DiskGiB)
+	}
+}
+
+// resources returns the resource values in the manifest.
+func (lm *limaManifest) resources() MachineResources {
+	memory, _ := parsesize(lm.Memory)
+	disk, _ := parsesize(lm.Disk)
+
+	return MachineResources{
+		CPUs:      lm.CPUs,
+		MemoryMiB: int(memory >> 20),
+		DiskGiB:   int(disk >> 30),
+	}
+}
+
+var sizeParts = regexp.MustCompile(`(?i)^([0-9]+(?:\.[0-9]+)?)\s*([kmgtp]?)i?b?$`)
+
+// parsesize converts a lima size string, such as "2GiB", into bytes.
+// Like lima, it treats all units as binary.
+func parsesize(size string) (uint64, error) {
+	parts := sizeParts.FindStringSubmatch(strings.TrimSpace(size))
+	if parts == nil {
+		return 0, fmt.Errorf("invalid size '%v'", size)
+	}
+
+	value, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%v'", size)
+	}
+
+	shift := strings.Index("kmgtp", strings.ToLower(parts[2])) + 1
+	if parts[2] == "" {
+		shift = 0
+	}
+
+	return uint64(value * float64(uint64(1)<<(10*shift))), nil
+}
@@ -0,0 +1,164 @@
+package driverlima
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// limactlTimeouts are the default timeouts for limactl commands. A
+// deadline set by the caller's context is used if it is earlier.
+// Commands not listed use defaultLimactlTimeout.
+var limactlTimeouts = map[string]time.Duration{
+	// create may download an image
+	"create": 30 * time.Minute,
+	"start":  10 * time.Minute,
+	"stop":   3 * time.Minute,
+	"rm":     3 * time.Minute,
+	"shell":  15 * time.Minute,
+	"copy":   15 * time.Minute,
+	"list":   30 * time.Second,
+	"edit":   time.Minute,
+}
+
+const defaultLimactlTimeout = 5 * time.Minute
+
+// limactlWaitDelay is how long a cancelled limactl command has to stop
+// the processes it started, such as lima's host agent, after it is
+// interrupted. Then, the command and any processes it left behind are
+// killed.
+var limactlWaitDelay = 2 * time.Second
+
+// TimeoutError is returned when a limactl command does not complete in
+// time. It matches ErrTimeout and context.DeadlineExceeded with
+// errors.Is.
+type TimeoutError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("limactl %v did not complete within %v", e.Operation, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Is makes a TimeoutError match ErrTimeout.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// interruptSignals stop the limactl commands run by methods that do not
+// take a context.
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// interruptcontext returns a context that is cancelled when the process
+// receives one of interruptSignals. limactl runs in its own process
+// group, so that it can be killed along with the processes it starts.
+// That also keeps Ctrl-C in a terminal from reaching it. Methods that do
+// not take a context use this, so that the signal kills limactl instead
+// of leaving it running after kutti exits.
+// The returned function must be called when the method is done. If a
+// signal was received, it is raised again, so that the program stops as
+// it would have without the driver.
+func interruptcontext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, interruptSignals...)
+
+	var received os.Signal
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case received = <-signals:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-exited
+		signal.Stop(signals)
+		cancel()
+		select {
+		case sig := <-signals:
+			if received == nil {
+				received = sig
+			}
+		default:
+		}
+		if received != nil {
+			raisesignal(received)
+		}
+	}
+}
+
+// limactlRun describes a limactl command to be run by runlimactl.
+type limactlRun struct {
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// runlimactl runs limactl with the specified arguments, after global
+// flags. If ctx is done, or the default timeout for the command elapses,
+// limactl is killed along with any processes it started in its process
+// group, and a *TimeoutError or the context's error is returned. If
+// limactl cannot be run, an error matching ErrLimactlNotFound is. A
+// non-zero exit is returned as an *exec.ExitError.
+func (d *Driver) runlimactl(ctx context.Context, run *limactlRun) error {
+	operation := "limactl"
+	if len(run.args) > 0 {
+		operation = run.args[0]
+	}
+
+	timeout, ok := limactlTimeouts[operation]
+	if !ok {
+		timeout = defaultLimactlTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, d.limactlpath, limactlargs(run.args...)...)
+	cmd.Stdin = run.stdin
+	cmd.Stdout = run.stdout
+	cmd.Stderr = run.stderr
+	cmd.WaitDelay = limactlWaitDelay
+	setprocessgroup(cmd)
+	cmd.Cancel = func() error {
+		time.AfterFunc(limactlWaitDelay, func() { killprocessgroup(cmd) })
+		return interruptprocessgroup(cmd)
+	}
+
+	err := cmd.Run()
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &TimeoutError{Operation: operation, Timeout: timeout.Round(time.Millisecond)}
+	case ctx.Err() != nil:
+		return fmt.Errorf("limactl %v cancelled: %w", operation, ctx.Err())
+	}
+
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrLimactlNotFound, err)
+	}
+
+	return err
+}
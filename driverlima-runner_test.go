@@ -0,0 +1,100 @@
+package driverlima
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// sleepinmachine runs sleep in a running test machine, through the
+// runner.
+func sleepinmachine(ctx context.Context, t *testing.T, m *Machine) error {
+	t.Helper()
+
+	_, err := m.driver.runwithresults(ctx, "shell", "--workdir", "/", m.qName(), "sleep", "30")
+	return err
+}
+
+func TestRunnerTimeouts(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake limactl runs sleep on the host")
+	}
+
+	d := testdriver(t)
+	m := testmachine(t, d, "runner1")
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	savedtimeout := limactlTimeouts["shell"]
+	savedwaitdelay := limactlWaitDelay
+	limactlWaitDelay = 100 * time.Millisecond
+	t.Cleanup(func() {
+		limactlTimeouts["shell"] = savedtimeout
+		limactlWaitDelay = savedwaitdelay
+	})
+
+	t.Run("default timeout", func(t *testing.T) {
+		limactlTimeouts["shell"] = 200 * time.Millisecond
+		defer func() { limactlTimeouts["shell"] = savedtimeout }()
+
+		err := sleepinmachine(context.Background(), t, m)
+
+		var timeouterr *TimeoutError
+		if !errors.As(err, &timeouterr) {
+			t.Fatalf("expected *TimeoutError, got %v", err)
+		}
+		if timeouterr.Operation != "shell" || timeouterr.Timeout != 200*time.Millisecond {
+			t.Errorf("unexpected timeout error %+v", timeouterr)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("expected timeout error to match context.DeadlineExceeded")
+		}
+	})
+
+	t.Run("caller deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+
+		err := sleepinmachine(ctx, t, m)
+
+		var timeouterr *TimeoutError
+		if !errors.As(err, &timeouterr) {
+			t.Fatalf("expected *TimeoutError, got %v", err)
+		}
+		if timeouterr.Timeout > 300*time.Millisecond {
+			t.Errorf("expected timeout of at most 300ms, got %v", timeouterr.Timeout)
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+
+		err := sleepinmachine(ctx, t, m)
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error matching context.Canceled, got %v", err)
+		}
+		var timeouterr *TimeoutError
+		if errors.As(err, &timeouterr) {
+			t.Errorf("expected cancellation not to be reported as a timeout, got %v", err)
+		}
+	})
+
+	t.Run("machine operation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := m.StopContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected error matching context.Canceled, got %v", err)
+		}
+		if status := m.Status(); status != "Running" {
+			t.Errorf("expected machine to keep running, got %v", status)
+		}
+	})
+}
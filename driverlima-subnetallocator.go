@@ -0,0 +1,239 @@
+package driverlima
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/kuttiproject/workspace"
+)
+
+// ClusterNetworkPool is the IPv4 range from which the driver allocates
+// subnets for cluster networks. Kubernetes pod and service ranges should
+// be chosen outside it.
+var ClusterNetworkPool = "192.168.128.0/18"
+
+// ClusterNetworkPrefixLength is the prefix length of the subnet allocated
+// to each cluster network.
+var ClusterNetworkPrefixLength = 24
+
+// subnetAllocator hands out non-overlapping subnets of a fixed size from
+// a pool, in address order. Subnets that overlap anything in inuse are
+// skipped.
+type subnetAllocator struct {
+	pool      *net.IPNet
+	prefixlen int
+	allocated map[string]*net.IPNet
+	inuse     []*net.IPNet
+}
+
+func newsubnetallocator(pool string, prefixlen int, allocations map[string]string, inuse []*net.IPNet) (*subnetAllocator, error) {
+	_, poolnet, err := net.ParseCIDR(pool)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster network pool: %w", err)
+	}
+
+	poolones, bits := poolnet.Mask.Size()
+	if bits != 32 {
+		return nil, fmt.Errorf("cluster network pool %v is not an IPv4 range", pool)
+	}
+	if prefixlen < poolones || prefixlen > 30 {
+		return nil, fmt.Errorf("invalid cluster network prefix length %v for pool %v", prefixlen, pool)
+	}
+
+	sa := &subnetAllocator{
+		pool:      poolnet,
+		prefixlen: prefixlen,
+		allocated: map[string]*net.IPNet{},
+		inuse:     inuse,
+	}
+
+	for key, cidr := range allocations {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allocation %v for %v: %w", cidr, key, err)
+		}
+		sa.allocated[key] = subnet
+	}
+
+	return sa, nil
+}
+
+// allocate returns the subnet allocated to key, allocating the first
+// free subnet in the pool if required. An earlier allocation is replaced
+// if it now overlaps something else.
+func (sa *subnetAllocator) allocate(key string) (*net.IPNet, error) {
+	if subnet, ok := sa.allocated[key]; ok && sa.conflict(key, subnet) == "" {
+		return subnet, nil
+	}
+	delete(sa.allocated, key)
+
+	poolones, _ := sa.pool.Mask.Size()
+	count := uint32(1) << (sa.prefixlen - poolones)
+	step := uint32(1) << (32 - sa.prefixlen)
+	base := binary.BigEndian.Uint32(sa.pool.IP.To4())
+
+	for i := uint32(0); i < count; i++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+i*step)
+		candidate := &net.IPNet{IP: ip, Mask: net.CIDRMask(sa.prefixlen, 32)}
+
+		if sa.conflict(key, candidate) == "" {
+			sa.allocated[key] = candidate
+			return candidate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w %v", ErrSubnetPoolExhausted, sa.pool)
+}
+
+// reserve allocates a specific subnet to key, if it does not overlap
+// anything else.
+func (sa *subnetAllocator) reserve(key string, subnet *net.IPNet) error {
+	if conflict := sa.conflict(key, subnet); conflict != "" {
+		return fmt.Errorf("subnet %v overlaps %v", subnet, conflict)
+	}
+
+	sa.allocated[key] = subnet
+	return nil
+}
+
+// release frees the subnet allocated to key.
+func (sa *subnetAllocator) release(key string) {
+	delete(sa.allocated, key)
+}
+
+// conflict describes what a subnet for key overlaps, or returns an empty
+// string if it overlaps nothing.
+func (sa *subnetAllocator) conflict(key string, subnet *net.IPNet) string {
+	// Sorted, so that the result is deterministic
+	keys := make([]string, 0, len(sa.allocated))
+	for other := range sa.allocated {
+		keys = append(keys, other)
+	}
+	sort.Strings(keys)
+
+	for _, other := range keys {
+		if other != key && overlaps(subnet, sa.allocated[other]) {
+			return fmt.Sprintf("subnet %v allocated to %v", sa.allocated[other], other)
+		}
+	}
+
+	for _, used := range sa.inuse {
+		if overlaps(subnet, used) {
+			return fmt.Sprintf("network %v in use on this host", used)
+		}
+	}
+
+	return ""
+}
+
+// allocations returns the allocated subnets as CIDR strings.
+func (sa *subnetAllocator) allocations() map[string]string {
+	result := map[string]string{}
+	for key, subnet := range sa.allocated {
+		result[key] = subnet.String()
+	}
+	return result
+}
+
+func overlaps(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// Allocations are stored in the workspace configuration directory, keyed
+// by cluster name.
+const subnetsConfigFile = "limasubnets.json"
+
+var (
+	subnetdata             = &subnetconfigdata{}
+	subnetconfigmanager, _ = workspace.NewFileConfigManager(subnetsConfigFile, subnetdata)
+)
+
+type subnetconfigdata struct {
+	allocations map[string]string
+}
+
+func (scd *subnetconfigdata) Serialize() ([]byte, error) {
+	return json.Marshal(scd.allocations)
+}
+
+func (scd *subnetconfigdata) Deserialize(data []byte) error {
+	loaddata := map[string]string{}
+	err := json.Unmarshal(data, &loaddata)
+	if err == nil {
+		scd.allocations = loaddata
+	}
+	return err
+}
+
+func (scd *subnetconfigdata) SetDefaults() {
+	scd.allocations = map[string]string{}
+}
+
+// withsubnetallocator loads the cluster subnet allocations, and calls
+// update with an allocator that avoids the subnets of all lima networks
+// other than excludenetwork, and all networks routed by the host. The
+// allocations are saved if update succeeds.
+// It must be called from within withnetworkconfig.
+func withsubnetallocator(nc *limaNetworkConfig, excludenetwork string, update func(sa *subnetAllocator) error) error {
+	err := subnetconfigmanager.Load()
+	if err != nil {
+		return err
+	}
+
+	networks, err := nc.networks()
+	if err != nil {
+		return err
+	}
+
+	inuse, err := hostroutes()
+	if err != nil {
+		return fmt.Errorf("could not read host routes: %w", err)
+	}
+
+	for name, network := range networks {
+		if subnet := network.subnet(); subnet != nil && name != excludenetwork {
+			inuse = append(inuse, subnet)
+		}
+	}
+
+	sa, err := newsubnetallocator(
+		ClusterNetworkPool,
+		ClusterNetworkPrefixLength,
+		subnetdata.allocations,
+		inuse,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = update(sa)
+	if err != nil {
+		return err
+	}
+
+	subnetdata.allocations = sa.allocations()
+	return subnetconfigmanager.Save()
+}
+
+// hostroutes returns the networks that the host has addresses on or
+// routes to, other than the default route. It is a variable so that tests
+// can make it deterministic.
+var hostroutes = func() ([]*net.IPNet, error) {
+	result := []*net.IPNet{}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLoopback() {
+			result = append(result, &net.IPNet{IP: ipnet.IP.Mask(ipnet.Mask).To4(), Mask: ipnet.Mask})
+		}
+	}
+
+	return append(result, hostroutetable()...), nil
+}
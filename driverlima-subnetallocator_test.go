@@ -0,0 +1,208 @@
+package driverlima
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func parsesubnets(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+
+	result := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, subnet)
+	}
+	return result
+}
+
+func TestSubnetAllocate(t *testing.T) {
+	tests := []struct {
+		name        string
+		pool        string
+		prefixlen   int
+		allocations map[string]string
+		inuse       []string
+		want        string
+		wanterr     string
+	}{
+		{
+			name:      "first subnet",
+			pool:      "192.168.128.0/18",
+			prefixlen: 24,
+			want:      "192.168.128.0/24",
+		},
+		{
+			name:        "skips allocated",
+			pool:        "192.168.128.0/18",
+			prefixlen:   24,
+			allocations: map[string]string{"other": "192.168.128.0/24"},
+			want:        "192.168.129.0/24",
+		},
+		{
+			name:      "skips host routes",
+			pool:      "192.168.128.0/18",
+			prefixlen: 24,
+			inuse:     []string{"192.168.128.0/23", "192.168.130.64/26"},
+			want:      "192.168.131.0/24",
+		},
+		{
+			name:      "skips enclosing network",
+			pool:      "10.10.0.0/16",
+			prefixlen: 24,
+			inuse:     []string{"10.0.0.0/8"},
+			wanterr:   "no free subnet",
+		},
+		{
+			name:        "existing allocation",
+			pool:        "192.168.128.0/18",
+			prefixlen:   24,
+			allocations: map[string]string{"test": "192.168.140.0/24"},
+			want:        "192.168.140.0/24",
+		},
+		{
+			name:        "existing allocation now in use",
+			pool:        "192.168.128.0/18",
+			prefixlen:   24,
+			allocations: map[string]string{"test": "192.168.128.0/24"},
+			inuse:       []string{"192.168.128.0/24"},
+			want:        "192.168.129.0/24",
+		},
+		{
+			name:        "exhausted",
+			pool:        "192.168.128.0/23",
+			prefixlen:   24,
+			allocations: map[string]string{"a": "192.168.128.0/24"},
+			inuse:       []string{"192.168.129.0/24"},
+			wanterr:     "no free subnet",
+		},
+		{
+			name:      "invalid pool",
+			pool:      "192.168.128.0",
+			prefixlen: 24,
+			wanterr:   "invalid cluster network pool",
+		},
+		{
+			name:      "prefix too short",
+			pool:      "192.168.128.0/18",
+			prefixlen: 16,
+			wanterr:   "prefix length",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sa, err := newsubnetallocator(test.pool, test.prefixlen, test.allocations, parsesubnets(t, test.inuse...))
+			if err == nil {
+				var subnet *net.IPNet
+				subnet, err = sa.allocate("test")
+				if err == nil && subnet.String() != test.want {
+					t.Errorf("expected %v, got %v", test.want, subnet)
+				}
+			}
+
+			if test.wanterr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wanterr) {
+				t.Errorf("expected error containing %q, got %v", test.wanterr, err)
+			}
+		})
+	}
+}
+
+func TestSubnetAllocatorDeterministic(t *testing.T) {
+	allocate := func() map[string]string {
+		sa, err := newsubnetallocator("192.168.128.0/18", 24, nil, parsesubnets(t, "192.168.129.0/24"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			_, err = sa.allocate(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return sa.allocations()
+	}
+
+	expected := map[string]string{
+		"a": "192.168.128.0/24",
+		"b": "192.168.130.0/24",
+		"c": "192.168.131.0/24",
+	}
+	for i := 0; i < 3; i++ {
+		actual := allocate()
+		for key, cidr := range expected {
+			if actual[key] != cidr {
+				t.Fatalf("expected %v for %v, got %v", cidr, key, actual[key])
+			}
+		}
+	}
+}
+
+func TestSubnetReserveAndRelease(t *testing.T) {
+	sa, err := newsubnetallocator(
+		"192.168.128.0/18",
+		24,
+		map[string]string{"other": "192.168.128.0/24"},
+		parsesubnets(t, "10.0.0.0/8"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sa.reserve("test", parsesubnets(t, "192.168.128.0/23")[0])
+	if err == nil || !strings.Contains(err.Error(), "allocated to other") {
+		t.Errorf("expected overlap with other cluster, got %v", err)
+	}
+
+	err = sa.reserve("test", parsesubnets(t, "10.1.0.0/16")[0])
+	if err == nil || !strings.Contains(err.Error(), "in use on this host") {
+		t.Errorf("expected overlap with host network, got %v", err)
+	}
+
+	// Subnets outside the pool can be reserved
+	err = sa.reserve("test", parsesubnets(t, "172.20.0.0/24")[0])
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	sa.release("other")
+	subnet, err := sa.allocate("new")
+	if err != nil || subnet.String() != "192.168.128.0/24" {
+		t.Errorf("expected released subnet to be reused, got %v, %v", subnet, err)
+	}
+}
+
+func TestSubnetPoolExhausted(t *testing.T) {
+	d := testdriver(t)
+	testnetworksconfig(t, testNetworksConfig)
+
+	savedpool := ClusterNetworkPool
+	ClusterNetworkPool = "192.168.128.0/23"
+	t.Cleanup(func() { ClusterNetworkPool = savedpool })
+
+	network, err := d.NewNetwork("pool1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.DeleteNetwork("pool1") })
+	if network.CIDR() != "192.168.128.0/24" {
+		t.Errorf("expected 192.168.128.0/24, got %v", network.CIDR())
+	}
+
+	// The other subnet in the pool is routed by the test host
+	_, err = d.NewNetwork("pool2")
+	if !errors.Is(err, ErrSubnetPoolExhausted) {
+		t.Errorf("expected ErrSubnetPoolExhausted, got %v", err)
+	}
+}
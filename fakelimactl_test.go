@@ -0,0 +1,984 @@
+package driverlima
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuttiproject/workspace"
+	"gopkg.in/yaml.v3"
+)
+
+// The test binary doubles as a fake limactl. TestMain copies the test
+// binary into a temporary directory as "limactl", puts that directory
+// first on the PATH, and sets fakeLimactlEnv. When the copy is run by the
+// driver, TestMain sees the environment variable and runs fakelimactl
+// instead of the tests.
+//
+// The fake keeps its state under LIMA_HOME, one directory per instance,
+// laid out like lima's own: the instance manifest is stored as lima.yaml,
+// and the emulated VM state in fakeinstance.json.
+const (
+	fakeLimactlEnv = "DRIVERLIMA_FAKE_LIMACTL"
+	fakeStateFile  = "fakeinstance.json"
+	fakeListFile   = "fakelist.json"
+	// fakeStartSleepEnv, if set, makes limactl start run sleep, and
+	// write its process id to the file named by the variable.
+	fakeStartSleepEnv = "DRIVERLIMA_FAKE_START_SLEEP"
+	// fakeStartInterruptEnv, if set with fakeStartSleepEnv, makes sleep
+	// ignore SIGINT, like lima's host agent. If it is "cleanup", limactl
+	// start kills sleep when interrupted, and creates the file named by
+	// fakeStartSleepEnv with ".interrupted" appended. If it is "ignore",
+	// limactl start ignores SIGINT as well.
+	fakeStartInterruptEnv = "DRIVERLIMA_FAKE_START_INTERRUPT"
+	testK8sVersion        = "1.33"
+	testImageURLPath      = "/images/kutti-k8s-1.33.qcow2"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeLimactlEnv) != "" {
+		os.Exit(fakelimactl(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	os.Exit(runtests(m))
+}
+
+// runtests sets up a hermetic environment for the tests: a temporary
+// kutti workspace, lima home and lima cache, the fake limactl on the PATH,
+// and a local server for the image list and images.
+func runtests(m *testing.M) int {
+	tempdir, err := os.MkdirTemp("", "driverlimatest")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(tempdir)
+
+	err = setupfakeenvironment(tempdir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	imageserver := httptest.NewServer(http.HandlerFunc(serveimagelist))
+	defer imageserver.Close()
+	ImagesSourceURL = imageserver.URL + "/" + imagesConfigFile
+
+	return m.Run()
+}
+
+func setupfakeenvironment(tempdir string) error {
+	err := workspace.Set(filepath.Join(tempdir, "workspace"))
+	if err != nil {
+		return err
+	}
+
+	bindir := filepath.Join(tempdir, "bin")
+	err = os.MkdirAll(bindir, 0755)
+	if err != nil {
+		return err
+	}
+
+	testbinary, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	fakepath := filepath.Join(bindir, "limactl")
+	if runtime.GOOS == "windows" {
+		fakepath += ".exe"
+	}
+
+	err = copyexecutable(testbinary, fakepath)
+	if err != nil {
+		return err
+	}
+
+	limahome := filepath.Join(tempdir, "lima")
+	err = os.MkdirAll(limahome, 0755)
+	if err != nil {
+		return err
+	}
+
+	os.Setenv("PATH", bindir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	os.Setenv("LIMA_HOME", limahome)
+	os.Setenv("LIMA_CACHE_HOME", filepath.Join(tempdir, "limacache"))
+	os.Setenv(fakeLimactlEnv, "1")
+
+	// Subnet allocation should not depend on the networks of the machine
+	// running the tests
+	hostroutes = func() ([]*net.IPNet, error) {
+		_, subnet, err := net.ParseCIDR(testHostRoute)
+		return []*net.IPNet{subnet}, err
+	}
+
+	return nil
+}
+
+// testHostRoute is the only network the host appears to route, during
+// tests.
+const testHostRoute = "192.168.129.0/24"
+
+func copyexecutable(src string, dest string) error {
+	srcfile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcfile.Close()
+
+	destfile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	defer destfile.Close()
+
+	_, err = io.Copy(destfile, srcfile)
+	return err
+}
+
+// testimagecontent returns the test disk image for an architecture: a
+// qcow2 version 3 header, followed by padding.
+func testimagecontent(arch string) []byte {
+	header := make([]byte, 104)
+	copy(header, "QFI\xfb")
+	binary.BigEndian.PutUint32(header[4:], 3)       // version
+	binary.BigEndian.PutUint32(header[20:], 16)     // cluster_bits
+	binary.BigEndian.PutUint64(header[24:], 10<<30) // size
+	binary.BigEndian.PutUint32(header[100:], 104)   // header_length
+
+	padding := bytes.Repeat([]byte(arch), (64<<10)/len(arch))
+	return append(header, padding...)
+}
+
+// testimagedigest returns the digest of the test disk image for an
+// architecture. The aarch64 image uses sha256, and the x86_64 image uses
+// sha512.
+func testimagedigest(arch string) string {
+	h := sha256.New()
+	if arch == "x86_64" {
+		h = sha512.New()
+	}
+	h.Write(testimagecontent(arch))
+	return digestof(h)
+}
+
+// testimagelist returns the contents of the image list served to tests.
+func testimagelist(baseurl string) map[string]*Image {
+	return map[string]*Image{
+		testK8sVersion: {
+			ImageK8sVersion: testK8sVersion,
+			ImageFiles: []ImageFile{
+				{
+					Location: baseurl + "/aarch64" + testImageURLPath,
+					Arch:     "aarch64",
+					Digest:   testimagedigest("aarch64"),
+				},
+				{
+					Location: baseurl + "/x86_64" + testImageURLPath,
+					Arch:     "x86_64",
+					Digest:   testimagedigest("x86_64"),
+				},
+			},
+		},
+	}
+}
+
+// serveimagelist serves the image list, and the disk images in it.
+func serveimagelist(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/"+imagesConfigFile {
+		json.NewEncoder(w).Encode(testimagelist("http://" + r.Host))
+		return
+	}
+
+	for arch := range validArchs {
+		if r.URL.Path == "/"+arch+testImageURLPath {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(testimagecontent(arch)))
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// testdriver returns a validated Driver that uses the fake limactl,
+// with the test image list loaded.
+func testdriver(t *testing.T) *Driver {
+	t.Helper()
+
+	d := &Driver{}
+	err := d.validate()
+	if err != nil {
+		t.Fatalf("fake limactl not found: %v", err)
+	}
+
+	err = d.UpdateImageList()
+	if err != nil {
+		t.Fatalf("could not load test image list: %v", err)
+	}
+
+	return d
+}
+
+// fakeinstance is the emulated state of a lima instance.
+type fakeinstance struct {
+	Status       string `json:"status"`
+	SSHLocalPort int    `json:"sshLocalPort"`
+	Hostname     string `json:"hostname"`
+	// NextStatus, if set, becomes the status after ListsBeforeNext
+	// more calls to `limactl list`.
+	NextStatus      string `json:"nextStatus,omitempty"`
+	ListsBeforeNext int    `json:"listsBeforeNext,omitempty"`
+	// FailNextList makes the next `limactl list` of the instance fail.
+	FailNextList bool `json:"failNextList,omitempty"`
+}
+
+func fakelimahome() string {
+	return os.Getenv("LIMA_HOME")
+}
+
+func fakeinstancedir(name string) string {
+	return filepath.Join(fakelimahome(), name)
+}
+
+func loadfakeinstance(name string) (*fakeinstance, error) {
+	data, err := os.ReadFile(filepath.Join(fakeinstancedir(name), fakeStateFile))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &fakeinstance{}
+	err = json.Unmarshal(data, result)
+	return result, err
+}
+
+func savefakeinstance(name string, inst *fakeinstance) error {
+	data, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(fakeinstancedir(name), fakeStateFile), data, 0644)
+}
+
+func fakeinstancenames() []string {
+	entries, _ := os.ReadDir(fakelimahome())
+	result := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(fakelimahome(), entry.Name(), fakeStateFile))
+		if err == nil {
+			result = append(result, entry.Name())
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// setfakestatus changes the emulated status of an instance. If after is
+// greater than zero, the status changes only after that many calls to
+// `limactl list`.
+func setfakestatus(t *testing.T, name string, status string, after int) {
+	t.Helper()
+
+	inst, err := loadfakeinstance(name)
+	if err != nil {
+		t.Fatalf("fake instance %v not found: %v", name, err)
+	}
+
+	if after > 0 {
+		inst.NextStatus = status
+		inst.ListsBeforeNext = after
+	} else {
+		inst.Status = status
+		inst.NextStatus = ""
+	}
+
+	err = savefakeinstance(name, inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakelistoutput is what limactl list prints when a test replaces its
+// output with setfakelistoutput.
+type fakelistoutput struct {
+	Stdout string
+	Stderr string
+}
+
+// setfakelistoutput makes limactl list print stdout and stderr, instead
+// of the instances it knows about, until the test ends.
+func setfakelistoutput(t *testing.T, stdout string, stderr string) {
+	t.Helper()
+
+	listfile := filepath.Join(fakelimahome(), fakeListFile)
+	data, _ := json.Marshal(fakelistoutput{Stdout: stdout, Stderr: stderr})
+	err := os.WriteFile(listfile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(listfile) })
+}
+
+// fakeinstanceconfig returns the parsed lima.yaml of an instance.
+func fakeinstanceconfig(t *testing.T, name string) map[string]any {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(fakeinstancedir(name), "lima.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := map[string]any{}
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// fakelimactl emulates the subset of limactl used by the driver. It
+// writes JSON log entries to stderr, like `limactl --log-format=json`,
+// and returns the process exit code.
+func fakelimactl(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fl := &fakerun{stdin: stdin, stdout: stdout, stderr: stderr}
+
+	// Skip global flags
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		if args[0] == "--log-level" || args[0] == "--log-format" {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return fl.fatalf("no command specified")
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "create":
+		return fl.create(args)
+	case "start":
+		return fl.start(args)
+	case "stop":
+		return fl.stop(args)
+	case "rm", "delete":
+		return fl.rm(args)
+	case "list", "ls":
+		return fl.list(args)
+	case "shell":
+		return fl.shell(args)
+	case "edit":
+		return fl.edit(args)
+	case "copy", "cp":
+		return fl.copy(args)
+	}
+
+	return fl.fatalf("unknown command %q for \"limactl\"", command)
+}
+
+type fakerun struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (fl *fakerun) log(level string, format string, a ...any) {
+	entry := LogEntry{
+		Level: level,
+		Msg:   fmt.Sprintf(format, a...),
+		Time:  time.Now().Format(time.RFC3339),
+	}
+	data, _ := json.Marshal(entry)
+	fmt.Fprintln(fl.stderr, string(data))
+}
+
+func (fl *fakerun) fatalf(format string, a ...any) int {
+	fl.log("fatal", format, a...)
+	return 1
+}
+
+// splitflags separates flags from positional arguments. Flags in
+// valueflags take the following argument as their value, unless
+// specified as --flag=value. Unless interspersed is true, everything
+// after the first positional argument is treated as positional.
+func splitflags(args []string, interspersed bool, valueflags ...string) (map[string]string, []string) {
+	flags := map[string]string{}
+	positional := []string{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			if !interspersed {
+				positional = append(positional, args[i:]...)
+				break
+			}
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasvalue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !hasvalue {
+			for _, vf := range valueflags {
+				if name == vf && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+			}
+		}
+		flags[name] = value
+	}
+
+	return flags, positional
+}
+
+func (fl *fakerun) instance(name string) (*fakeinstance, int) {
+	inst, err := loadfakeinstance(name)
+	if err != nil {
+		return nil, fl.fatalf("instance %q does not exist, run `limactl create --name=%s` to create a new instance", name, name)
+	}
+	return inst, 0
+}
+
+func (fl *fakerun) create(args []string) int {
+	flags, positional := splitflags(args, true, "name")
+	if len(positional) != 1 {
+		return fl.fatalf("expected exactly one template argument")
+	}
+
+	name := flags["name"]
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(positional[0]), ".yaml")
+	}
+
+	instdir := fakeinstancedir(name)
+	_, err := os.Stat(instdir)
+	if err == nil {
+		return fl.fatalf("instance %q already exists (%s)", name, instdir)
+	}
+
+	manifestdata, err := os.ReadFile(positional[0])
+	if err != nil {
+		return fl.fatalf("failed to read template %q: %v", positional[0], err)
+	}
+
+	var config map[string]any
+	err = yaml.Unmarshal(manifestdata, &config)
+	if err != nil {
+		return fl.fatalf("failed to parse template %q: %v", positional[0], err)
+	}
+
+	networks, _ := config["networks"].([]any)
+	for _, network := range networks {
+		netname, _ := network.(map[string]any)["lima"].(string)
+		if netname != "" && !fakenetworkdefined(netname) {
+			return fl.fatalf("networks.yaml: network %q is not defined", netname)
+		}
+	}
+
+	fl.log("info", "Creating an instance %q from template://default", name)
+
+	// Like lima's downloader, report progress as a bar redrawn after
+	// carriage returns, and log the completed download
+	fl.log("info", "Attempting to download the image")
+	fmt.Fprint(fl.stderr, "1.00 MiB / 2.00 MiB [=====>_____] 50.00%\r")
+	fmt.Fprintln(fl.stderr, "2.00 MiB / 2.00 MiB [===========] 100.00%")
+	fl.log("info", "Downloaded the image: 100%%")
+
+	err = os.MkdirAll(instdir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(instdir, "lima.yaml"), manifestdata, 0644)
+	}
+	if err == nil {
+		err = savefakeinstance(name, &fakeinstance{
+			Status:   "Stopped",
+			Hostname: "lima-" + name,
+		})
+	}
+	if err != nil {
+		return fl.fatalf("failed to create instance %q: %v", name, err)
+	}
+
+	fl.log("info", "Run `limactl start %s` to start the instance.", name)
+	return 0
+}
+
+func (fl *fakerun) start(args []string) int {
+	_, positional := splitflags(args, true)
+	if len(positional) != 1 {
+		return fl.fatalf("expected exactly one instance name")
+	}
+
+	name := positional[0]
+	inst, code := fl.instance(name)
+	if inst == nil {
+		return code
+	}
+
+	if inst.Status == "Running" {
+		fl.log("info", "The instance %q is already running. Run `limactl shell %s` to open the shell.", name, name)
+		return 0
+	}
+
+	fl.log("info", "Starting the instance %q with VM driver %q", name, "fake")
+
+	// Emulate a slow start, in a child process like lima's hostagent
+	if pidfile := os.Getenv(fakeStartSleepEnv); pidfile != "" {
+		interrupt := os.Getenv(fakeStartInterruptEnv)
+		if interrupt != "" {
+			// Ignored signals stay ignored in the child
+			signal.Ignore(os.Interrupt)
+		}
+
+		cmd := exec.Command("sleep", "30")
+		err := cmd.Start()
+		if err != nil {
+			return fl.fatalf("failed to start instance %q: %v", name, err)
+		}
+
+		interrupted := make(chan os.Signal, 1)
+		if interrupt == "cleanup" {
+			signal.Notify(interrupted, os.Interrupt)
+		}
+		os.WriteFile(pidfile, []byte(strconv.Itoa(cmd.Process.Pid)), 0644)
+
+		exited := make(chan struct{})
+		go func() {
+			cmd.Wait()
+			close(exited)
+		}()
+		select {
+		case <-interrupted:
+			cmd.Process.Kill()
+			<-exited
+			os.WriteFile(pidfile+".interrupted", nil, 0644)
+			return fl.fatalf("interrupted while starting instance %q", name)
+		case <-exited:
+		}
+	}
+
+	config, _ := loadfakeconfig(name)
+	inst.SSHLocalPort = fakesshport(name)
+	if ssh, ok := config["ssh"].(map[string]any); ok {
+		if port, ok := ssh["localPort"].(int); ok && port != 0 {
+			inst.SSHLocalPort = port
+		}
+	}
+	inst.Status = "Running"
+	inst.NextStatus = ""
+
+	err := savefakeinstance(name, inst)
+	if err != nil {
+		return fl.fatalf("failed to start instance %q: %v", name, err)
+	}
+
+	fl.log("info", "READY. Run `limactl shell %s` to open the shell.", name)
+	return 0
+}
+
+func (fl *fakerun) stop(args []string) int {
+	_, positional := splitflags(args, true)
+	if len(positional) != 1 {
+		return fl.fatalf("expected exactly one instance name")
+	}
+
+	name := positional[0]
+	inst, code := fl.instance(name)
+	if inst == nil {
+		return code
+	}
+
+	if inst.Status != "Running" {
+		return fl.fatalf("expected status %q, got %q", "Running", inst.Status)
+	}
+
+	fl.log("info", "Sending SIGINT to hostagent process")
+	inst.Status = "Stopped"
+	inst.NextStatus = ""
+
+	err := savefakeinstance(name, inst)
+	if err != nil {
+		return fl.fatalf("failed to stop instance %q: %v", name, err)
+	}
+
+	return 0
+}
+
+func (fl *fakerun) rm(args []string) int {
+	flags, positional := splitflags(args, true)
+	if len(positional) == 0 {
+		return fl.fatalf("expected at least one instance name")
+	}
+
+	_, force := flags["f"]
+	if !force {
+		_, force = flags["force"]
+	}
+
+	for _, name := range positional {
+		inst, code := fl.instance(name)
+		if inst == nil {
+			return code
+		}
+
+		if inst.Status == "Running" && !force {
+			return fl.fatalf("failed to delete instance %q: expected status %q, got %q (maybe use `limactl stop -f`?)", name, "Stopped", inst.Status)
+		}
+
+		err := os.RemoveAll(fakeinstancedir(name))
+		if err != nil {
+			return fl.fatalf("failed to delete instance %q: %v", name, err)
+		}
+
+		fl.log("info", "Deleted %q (%q)", name, fakeinstancedir(name))
+	}
+
+	return 0
+}
+
+func (fl *fakerun) list(args []string) int {
+	flags, positional := splitflags(args, true, "format")
+	if flags["format"] != "json" {
+		return fl.fatalf("the fake limactl only supports --format json")
+	}
+
+	// Tests can replace the output, to emulate other lima versions
+	if data, err := os.ReadFile(filepath.Join(fakelimahome(), fakeListFile)); err == nil {
+		var output fakelistoutput
+		json.Unmarshal(data, &output)
+		fmt.Fprint(fl.stdout, output.Stdout)
+		fmt.Fprint(fl.stderr, output.Stderr)
+		return 0
+	}
+
+	names := fakeinstancenames()
+	if len(positional) > 0 {
+		matched := []string{}
+		for _, name := range names {
+			for _, wanted := range positional {
+				if name == wanted {
+					matched = append(matched, name)
+				}
+			}
+		}
+		if len(matched) == 0 {
+			fl.log("warning", "No instance matching %v found.", strings.Join(positional, " "))
+			return 0
+		}
+		names = matched
+	}
+
+	for _, name := range names {
+		inst, err := loadfakeinstance(name)
+		if err != nil {
+			return fl.fatalf("failed to load instance %q: %v", name, err)
+		}
+
+		if inst.FailNextList {
+			inst.FailNextList = false
+			savefakeinstance(name, inst)
+			return fl.fatalf("failed to inspect instance %q", name)
+		}
+
+		if inst.NextStatus != "" {
+			if inst.ListsBeforeNext > 0 {
+				inst.ListsBeforeNext--
+			} else {
+				inst.Status = inst.NextStatus
+				inst.NextStatus = ""
+			}
+			savefakeinstance(name, inst)
+		}
+
+		sshport := 0
+		if inst.Status == "Running" {
+			sshport = inst.SSHLocalPort
+		}
+
+		config, _ := loadfakeconfig(name)
+		vmtype, _ := config["vmType"].(string)
+		arch, _ := config["arch"].(string)
+		cpus, _ := config["cpus"].(int)
+		memorysize, _ := config["memory"].(string)
+		memory, _ := parsesize(memorysize)
+		disksize, _ := config["disk"].(string)
+		disk, _ := parsesize(disksize)
+
+		data, _ := json.Marshal(map[string]any{
+			"name":          name,
+			"hostname":      inst.Hostname,
+			"status":        inst.Status,
+			"dir":           fakeinstancedir(name),
+			"vmType":        vmtype,
+			"arch":          arch,
+			"cpus":          cpus,
+			"memory":        memory,
+			"disk":          disk,
+			"sshLocalPort":  sshport,
+			"sshConfigFile": filepath.Join(fakeinstancedir(name), "ssh.config"),
+			"config":        config,
+		})
+		fmt.Fprintln(fl.stdout, string(data))
+	}
+
+	return 0
+}
+
+func (fl *fakerun) shell(args []string) int {
+	_, positional := splitflags(args, false, "workdir", "shell")
+	if len(positional) == 0 {
+		return fl.fatalf("requires at least 1 arg(s), only received 0")
+	}
+
+	name := positional[0]
+	inst, code := fl.instance(name)
+	if inst == nil {
+		return code
+	}
+
+	if inst.Status != "Running" {
+		return fl.fatalf("instance %q status is not %q", name, "Running")
+	}
+
+	command := positional[1:]
+	if len(command) > 0 && filepath.Base(command[0]) == "sudo" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		return fl.fatalf("the fake limactl does not support interactive shells")
+	}
+
+	switch filepath.Base(command[0]) {
+	case "get-primary-ip.sh":
+		fmt.Fprintf(fl.stdout, "192.168.104.%d\n", 2+fakeportoffset(name)%250)
+		return 0
+	case "set-hostname.sh":
+		if len(command) != 2 {
+			fmt.Fprintln(fl.stderr, "usage: set-hostname.sh NEWNAME")
+			return 1
+		}
+		inst.Hostname = command[1]
+		err := savefakeinstance(name, inst)
+		if err != nil {
+			fmt.Fprintln(fl.stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Like lima, log on the same stream as the command's standard error
+	fl.log("warning", "treating lima version %q as very old", "fake")
+
+	// Anything else runs on the host, which is good enough for
+	// commands that only read their input and write output.
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = fl.stdin
+	cmd.Stdout = fl.stdout
+	cmd.Stderr = fl.stderr
+	err := cmd.Run()
+	if exiterr, ok := err.(*exec.ExitError); ok {
+		return exiterr.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(fl.stderr, "bash: %v: command not found\n", command[0])
+		return 127
+	}
+	return 0
+}
+
+var setexpression = regexp.MustCompile(`^\s*((?:\.[A-Za-z0-9_]+)+)\s*=\s*(.*)$`)
+
+func (fl *fakerun) edit(args []string) int {
+	flags, positional := splitflags(args, true, "set")
+	if len(positional) != 1 {
+		return fl.fatalf("expected exactly one instance name or template file")
+	}
+
+	target := positional[0]
+	configpath := target
+	if !strings.HasSuffix(target, ".yaml") {
+		inst, code := fl.instance(target)
+		if inst == nil {
+			return code
+		}
+		if inst.Status == "Running" {
+			return fl.fatalf("cannot edit a running instance %q", target)
+		}
+		configpath = filepath.Join(fakeinstancedir(target), "lima.yaml")
+	}
+
+	setexpr, ok := flags["set"]
+	if !ok {
+		return fl.fatalf("the fake limactl only supports edit --set")
+	}
+
+	match := setexpression.FindStringSubmatch(setexpr)
+	if match == nil {
+		return fl.fatalf("failed to evaluate yq expression %q", setexpr)
+	}
+
+	var value any
+	err := yaml.Unmarshal([]byte(match[2]), &value)
+	if err != nil {
+		return fl.fatalf("failed to evaluate yq expression %q: %v", setexpr, err)
+	}
+
+	data, err := os.ReadFile(configpath)
+	if err != nil {
+		return fl.fatalf("failed to read %q: %v", configpath, err)
+	}
+
+	config := map[string]any{}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return fl.fatalf("failed to parse %q: %v", configpath, err)
+	}
+
+	keys := strings.Split(strings.TrimPrefix(match[1], "."), ".")
+	current := config
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+
+	data, err = yaml.Marshal(config)
+	if err == nil {
+		err = os.WriteFile(configpath, data, 0644)
+	}
+	if err != nil {
+		return fl.fatalf("failed to save %q: %v", configpath, err)
+	}
+
+	fl.log("info", "Instance %q configuration edited", target)
+	return 0
+}
+
+// copy copies files with cp on the host, because guest commands of the
+// fake also run on the host. Paths in the form INSTANCE:PATH must refer to
+// a running instance.
+func (fl *fakerun) copy(args []string) int {
+	flags, positional := splitflags(args, true)
+	if len(positional) < 2 {
+		return fl.fatalf("requires at least 2 arg(s), only received %v", len(positional))
+	}
+
+	cpargs := []string{}
+	if _, ok := flags["r"]; ok {
+		cpargs = append(cpargs, "-R")
+	}
+	if _, ok := flags["recursive"]; ok {
+		cpargs = append(cpargs, "-R")
+	}
+
+	for _, arg := range positional {
+		name, path, ok := strings.Cut(arg, ":")
+		if ok && !filepath.IsAbs(arg) {
+			inst, code := fl.instance(name)
+			if inst == nil {
+				return code
+			}
+			if inst.Status != "Running" {
+				return fl.fatalf("instance %q is stopped, run `limactl start %s` to start the instance", name, name)
+			}
+			arg = path
+		}
+		cpargs = append(cpargs, arg)
+	}
+
+	output, err := exec.Command("cp", cpargs...).CombinedOutput()
+	if err != nil {
+		return fl.fatalf("failed to copy: %v: %s", err, output)
+	}
+
+	return 0
+}
+
+func loadfakeconfig(name string) (map[string]any, error) {
+	data, err := os.ReadFile(filepath.Join(fakeinstancedir(name), "lima.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]any{}
+	err = yaml.Unmarshal(data, &config)
+	return config, err
+}
+
+// fakenetworkdefined checks if a network is defined in networks.yaml.
+// Like lima, it assumes the default networks if the file does not exist.
+func fakenetworkdefined(name string) bool {
+	data, err := os.ReadFile(filepath.Join(fakelimahome(), "_config", "networks.yaml"))
+	if os.IsNotExist(err) {
+		return name == "user-v2" || name == "shared" || name == "bridged" || name == "host"
+	}
+
+	config := struct {
+		Networks map[string]any `yaml:"networks"`
+	}{}
+	if yaml.Unmarshal(data, &config) != nil {
+		return false
+	}
+
+	_, ok := config.Networks[name]
+	return ok
+}
+
+func fakeportoffset(name string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % 1000)
+}
+
+// fakesshport mimics lima choosing a free local port for SSH.
+func fakesshport(name string) int {
+	return 60022 + fakeportoffset(name)
+}
+
+// testmachine creates a Machine through the driver, and removes it when
+// the test completes.
+func testmachine(t *testing.T, d *Driver, machinename string) *Machine {
+	t.Helper()
+
+	machine, err := d.NewMachine(machinename, "test", testK8sVersion)
+	if err != nil {
+		t.Fatalf("could not create test machine: %v", err)
+	}
+
+	t.Cleanup(func() {
+		qname := d.QualifiedMachineName(machinename, "test")
+		d.runwithresults(context.Background(), "rm", "-f", qname)
+		machinefile, _ := machineFilePath(qname)
+		os.Remove(machinefile)
+	})
+
+	return machine.(*Machine)
+}
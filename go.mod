@@ -6,6 +6,7 @@ require (
 	github.com/kuttiproject/drivercore v0.3.1
 	github.com/kuttiproject/workspace v0.3.1
 	github.com/pkg/errors v0.9.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require github.com/kuttiproject/kuttilog v0.2.1
@@ -1,13 +1,64 @@
 package driverlima
 
-import "github.com/kuttiproject/drivercore"
+import (
+	"fmt"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/kuttiproject/kuttilog"
+)
+
+// defaultImageArch is the architecture of images in lists that do not
+// specify one. Such lists were published only for Apple silicon.
+const defaultImageArch = "aarch64"
 
 type Image struct {
 	ImageK8sVersion string
-	// imageChecksum   string
-	ImageSourceURL  string
+	ImageFiles      []ImageFile
 	ImageStatus     drivercore.ImageStatus
 	ImageDeprecated bool
+
+	// ImageSourceURL is the single image location in older image lists.
+	// It is converted to an entry in ImageFiles when the list is loaded.
+	ImageSourceURL string `json:",omitempty"`
+}
+
+// ImageFile is the disk image of an Image for one architecture.
+type ImageFile struct {
+	Location string
+	Arch     string
+	Digest   string `json:",omitempty"`
+}
+
+// upgrade converts an Image loaded from an older image list.
+func (i *Image) upgrade() {
+	if i.ImageSourceURL != "" && len(i.ImageFiles) == 0 {
+		i.ImageFiles = []ImageFile{
+			{
+				Location: i.ImageSourceURL,
+				Arch:     defaultImageArch,
+			},
+		}
+	}
+	i.ImageSourceURL = ""
+}
+
+// file returns the disk image for the specified architecture, or nil.
+func (i *Image) file(arch string) *ImageFile {
+	for index := range i.ImageFiles {
+		if i.ImageFiles[index].Arch == arch {
+			return &i.ImageFiles[index]
+		}
+	}
+	return nil
+}
+
+// hostfile returns the disk image that can run on the host, or nil.
+func (i *Image) hostfile() *ImageFile {
+	platform, err := detecthost()
+	if err != nil {
+		return nil
+	}
+	return i.file(platform.arch)
 }
 
 // K8sVersion returns the version of Kubernetes components in the image.
@@ -15,8 +66,49 @@ func (i *Image) K8sVersion() string {
 	return i.ImageK8sVersion
 }
 
+// Archs returns the lima architectures for which the image is available.
+func (i *Image) Archs() []string {
+	result := make([]string, len(i.ImageFiles))
+	for index, file := range i.ImageFiles {
+		result[index] = file.Arch
+	}
+	return result
+}
+
+// Digest returns the digest of the image for the host's architecture, in
+// the form "sha256:<hex>" or "sha512:<hex>". It returns an empty string if
+// the image list does not specify a digest.
+func (i *Image) Digest() string {
+	file := i.hostfile()
+	if file == nil {
+		return ""
+	}
+	return file.Digest
+}
+
 // Status can be Notdownloaded, Downloaded or Unknown.
+// The status is determined by checking whether lima's download cache
+// contains the image, and whether it matches the image digest. So, it
+// reflects images downloaded by lima itself, and cache entries removed
+// outside kutti.
 func (i *Image) Status() drivercore.ImageStatus {
+	file := i.hostfile()
+	if file == nil {
+		i.ImageStatus = drivercore.ImageStatusUnknown
+		return i.ImageStatus
+	}
+
+	cached, err := iscached(file.Location, file.Digest)
+	switch {
+	case err != nil:
+		kuttilog.Printf(kuttilog.Verbose, "Could not check lima cache: %v", err)
+		i.ImageStatus = drivercore.ImageStatusUnknown
+	case cached:
+		i.ImageStatus = drivercore.ImageStatusDownloaded
+	default:
+		i.ImageStatus = drivercore.ImageStatusNotDownloaded
+	}
+
 	return i.ImageStatus
 }
 
@@ -26,36 +118,82 @@ func (i *Image) Deprecated() bool {
 }
 
 // Fetch downloads the image from the driver repository into the local cache.
-// The lima driver does not download or cache the image; lima itself does
-// that. So, Fetch silently continues if called.
+// The image is downloaded into lima's download cache, so that lima does not
+// need to download it again when creating a Machine.
 func (i *Image) Fetch() error {
-	i.ImageStatus = drivercore.ImageStatusDownloaded
-	return nil
+	return i.FetchWithProgress(nil)
 }
 
 // FetchWithProgress downloads the image from the driver repository into the
 // local cache, and reports progress via the supplied callback. The callback
 // reports current and total in bytes.
-// The lima driver does not download or cache the image; lima itself does
-// that. So, FetchWithProgress silently continues if called.
+// The image is downloaded into lima's download cache, so that lima does not
+// need to download it again when creating a Machine. An interrupted download
+// is resumed, and the download is verified against the image digest. An image
+// already in lima's cache is not downloaded again.
 func (i *Image) FetchWithProgress(progress func(current int64, total int64)) error {
-	progress(100, 100)
+	file := i.hostfile()
+	if file == nil {
+		return fmt.Errorf("no image for K8s version %v is available for this host", i.ImageK8sVersion)
+	}
+
+	cached, err := iscached(file.Location, file.Digest)
+	if err != nil {
+		return err
+	}
+
+	if !cached {
+		err = downloadtocache(file.Location, file.Digest, progress)
+		if err != nil {
+			return err
+		}
+	}
+
 	i.ImageStatus = drivercore.ImageStatusDownloaded
-	return nil
+	return imageconfigmanager.Save()
 }
 
 // FromFile imports the image from the local filesystem into the local cache.
-// The lima driver does not download or cache the image; lima itself does
-// that. So, FromFile silently continues if called.
+// The file must be a qcow2 image, and must match the image digest if there
+// is one. It is placed into lima's download cache, where lima will find it
+// instead of downloading the image.
 func (i *Image) FromFile(filepath string) error {
+	file := i.hostfile()
+	if file == nil {
+		return fmt.Errorf("no image for K8s version %v is available for this host", i.ImageK8sVersion)
+	}
+
+	err := validateqcow2(filepath)
+	if err != nil {
+		return err
+	}
+
+	if file.Digest == "" {
+		kuttilog.Printf(kuttilog.Info, "No digest available for image, not verifying %v.", filepath)
+	}
+
+	err = importtocache(filepath, file.Location, file.Digest)
+	if err != nil {
+		return err
+	}
+
 	i.ImageStatus = drivercore.ImageStatusDownloaded
-	return nil
+	return imageconfigmanager.Save()
 }
 
 // PurgeLocal removes the image from the local cache.
-// The lima driver does not download or cache the image; lima itself does
-// that. So, PurgeLocal silently continues if called.
+// The image is removed from lima's download cache.
 func (i *Image) PurgeLocal() error {
+	file := i.hostfile()
+	if file == nil {
+		return fmt.Errorf("no image for K8s version %v is available for this host", i.ImageK8sVersion)
+	}
+
+	err := removefromcache(file.Location)
+	if err != nil {
+		return err
+	}
+
 	i.ImageStatus = drivercore.ImageStatusNotDownloaded
-	return nil
+	return imageconfigmanager.Save()
 }
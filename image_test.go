@@ -0,0 +1,407 @@
+package driverlima
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func testimage(t *testing.T) *Image {
+	t.Helper()
+
+	d := testdriver(t)
+	image, err := d.GetImage(testK8sVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return image.(*Image)
+}
+
+// qcow2header returns a synthetic qcow2 header, padded to size bytes.
+func qcow2header(version uint32, virtualsize uint64, size int) []byte {
+	header := make([]byte, max(size, qcow2V3HeaderLength))
+	copy(header, qcow2Magic)
+	binary.BigEndian.PutUint32(header[qcow2VersionOffset:], version)
+	binary.BigEndian.PutUint64(header[qcow2SizeOffset:], virtualsize)
+	binary.BigEndian.PutUint32(header[qcow2HeaderLengthOffset:], qcow2V3HeaderLength)
+	return header[:size]
+}
+
+func TestImageFromFile(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+	if image.Digest() != testimagedigest(file.Arch) {
+		t.Fatalf("unexpected image digest %v", image.Digest())
+	}
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	badmagic := qcow2header(3, 1<<30, 4096)
+	copy(badmagic, "QFI\x00")
+
+	tests := []struct {
+		name    string
+		content []byte
+		wanterr string
+	}{
+		{"empty", []byte{}, "smaller than a qcow2 header"},
+		{"too small", qcow2header(3, 1<<30, 71), "smaller than a qcow2 header"},
+		{"bad magic", badmagic, "bad magic number"},
+		{"raw image", bytes.Repeat([]byte{0}, 4096), "bad magic number"},
+		{"unsupported version", qcow2header(4, 1<<30, 4096), "version 4"},
+		{"truncated v3 header", qcow2header(3, 1<<30, 80), "truncated"},
+		{"zero size", qcow2header(3, 0, 4096), "virtual disk size is zero"},
+		{"digest mismatch", testimagecontent("other"), "digest mismatch"},
+		{"valid", testimagecontent(file.Arch), ""},
+	}
+
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			imagepath := filepath.Join(dir, strings.ReplaceAll(test.name, " ", "-")+".qcow2")
+			err := os.WriteFile(imagepath, test.content, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = image.FromFile(imagepath)
+			if test.wanterr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wanterr) {
+					t.Errorf("expected error containing %q, got %v", test.wanterr, err)
+				}
+				if image.Status() != drivercore.ImageStatusNotDownloaded {
+					t.Errorf("expected status %v, got %v", drivercore.ImageStatusNotDownloaded, image.Status())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("FromFile failed: %v", err)
+			}
+			if image.Status() != drivercore.ImageStatusDownloaded {
+				t.Errorf("expected status %v, got %v", drivercore.ImageStatusDownloaded, image.Status())
+			}
+
+			data, err := os.ReadFile(filepath.Join(cachedir, cacheDataFile))
+			if err != nil || !bytes.Equal(data, test.content) {
+				t.Errorf("image not imported into cache: %v", err)
+			}
+		})
+	}
+
+	if err := image.FromFile(filepath.Join(dir, "missing.qcow2")); !os.IsNotExist(err) {
+		t.Errorf("expected file not found error, got %v", err)
+	}
+
+	if err := image.FromFile(dir); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("expected error importing a directory, got %v", err)
+	}
+}
+
+func TestImageFromFileWithoutDigest(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+	file.Digest = ""
+	file.Location += "?nodigest"
+
+	nodigestimage := &Image{
+		ImageK8sVersion: testK8sVersion,
+		ImageFiles:      []ImageFile{file},
+	}
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	// Metadata of a previously cached file does not describe the import
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"sha256.digest", cacheTimeFile} {
+		err = os.WriteFile(filepath.Join(cachedir, filename), []byte("stale"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imagepath := filepath.Join(t.TempDir(), "v2.qcow2")
+	err = os.WriteFile(imagepath, qcow2header(2, 1<<30, 4096), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = nodigestimage.FromFile(imagepath)
+	if err != nil {
+		t.Fatalf("FromFile failed: %v", err)
+	}
+	if nodigestimage.Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("expected status %v, got %v", drivercore.ImageStatusDownloaded, nodigestimage.Status())
+	}
+
+	for _, filename := range []string{"sha256.digest", cacheTimeFile} {
+		if _, err := os.Stat(filepath.Join(cachedir, filename)); !os.IsNotExist(err) {
+			t.Errorf("stale %v left in cache", filename)
+		}
+	}
+}
+
+// hostimagefile returns a copy of the test image file for the host.
+func hostimagefile(t *testing.T, image *Image) ImageFile {
+	t.Helper()
+
+	file := image.hostfile()
+	if file == nil {
+		t.Fatal("no test image for host")
+	}
+
+	return *file
+}
+
+func TestImageFetch(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+	content := testimagecontent(file.Arch)
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	// Pretend that an earlier download was interrupted
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(cachedir, cachePartialFile), content[:1000], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first, last, total int64 = -1, 0, 0
+	err = image.FetchWithProgress(func(current int64, t int64) {
+		if first < 0 {
+			first = current
+		}
+		last, total = current, t
+	})
+	if err != nil {
+		t.Fatalf("FetchWithProgress failed: %v", err)
+	}
+
+	if first <= 1000 {
+		t.Errorf("download not resumed: first progress report was %v bytes", first)
+	}
+	if last != int64(len(content)) || total != int64(len(content)) {
+		t.Errorf("expected final progress %v/%v, got %v/%v", len(content), len(content), last, total)
+	}
+
+	data, err := os.ReadFile(filepath.Join(cachedir, cacheDataFile))
+	if err != nil || string(data) != string(content) {
+		t.Errorf("image not cached correctly: %v", err)
+	}
+
+	url, _ := os.ReadFile(filepath.Join(cachedir, cacheURLFile))
+	if string(url) != file.Location {
+		t.Errorf("expected cached url %v, got %v", file.Location, string(url))
+	}
+
+	digest, _ := os.ReadFile(filepath.Join(cachedir, cachedigestfile(file.Digest)))
+	if string(digest) != file.Digest {
+		t.Errorf("expected cached digest %v, got %v", file.Digest, string(digest))
+	}
+
+	if _, err := os.Stat(filepath.Join(cachedir, cachePartialFile)); !os.IsNotExist(err) {
+		t.Error("partial download not removed")
+	}
+
+	if image.ImageStatus != drivercore.ImageStatusDownloaded {
+		t.Errorf("expected status %v, got %v", drivercore.ImageStatusDownloaded, image.ImageStatus)
+	}
+}
+
+func TestImageFetchCached(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(cachedir, cacheDataFile), testimagecontent(file.Arch), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	downloaded := false
+	err = image.FetchWithProgress(func(current int64, total int64) {
+		downloaded = true
+	})
+	if err != nil {
+		t.Fatalf("FetchWithProgress failed: %v", err)
+	}
+
+	if downloaded {
+		t.Error("cached image downloaded again")
+	}
+	if image.ImageStatus != drivercore.ImageStatusDownloaded {
+		t.Errorf("expected status %v, got %v", drivercore.ImageStatusDownloaded, image.ImageStatus)
+	}
+}
+
+func TestImageFetchStaleMetadata(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+	file.Digest = ""
+
+	nodigestimage := &Image{
+		ImageK8sVersion: testK8sVersion,
+		ImageFiles:      []ImageFile{file},
+	}
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	// Pretend that an earlier file was cached, and then removed
+	err = os.MkdirAll(cachedir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"sha256.digest", "sha512.digest", cacheTimeFile, cacheTypeFile} {
+		err = os.WriteFile(filepath.Join(cachedir, filename), []byte("stale"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = nodigestimage.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+
+	for _, filename := range []string{"sha256.digest", "sha512.digest", cacheTimeFile, cacheTypeFile} {
+		data, err := os.ReadFile(filepath.Join(cachedir, filename))
+		if err == nil && string(data) == "stale" {
+			t.Errorf("stale %v left in cache", filename)
+		}
+	}
+}
+
+func TestImageFetchDigestMismatch(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+
+	// The image for the other architecture will not match the digest
+	otherarch := "x86_64"
+	if file.Arch == otherarch {
+		otherarch = "aarch64"
+	}
+	file.Location = image.file(otherarch).Location
+
+	badimage := &Image{
+		ImageK8sVersion: testK8sVersion,
+		ImageFiles:      []ImageFile{file},
+	}
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	err = badimage.Fetch()
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+
+	for _, filename := range []string{cacheDataFile, cachePartialFile} {
+		if _, err := os.Stat(filepath.Join(cachedir, filename)); !os.IsNotExist(err) {
+			t.Errorf("%v left in cache after digest mismatch", filename)
+		}
+	}
+}
+
+func TestImageStatus(t *testing.T) {
+	image := testimage(t)
+	file := hostimagefile(t, image)
+
+	cachedir, err := imagecachedir(file.Location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(cachedir) })
+
+	if image.Status() != drivercore.ImageStatusNotDownloaded {
+		t.Errorf("expected status %v before fetch, got %v", drivercore.ImageStatusNotDownloaded, image.Status())
+	}
+
+	err = image.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("expected status %v after fetch, got %v", drivercore.ImageStatusDownloaded, image.Status())
+	}
+
+	// A cache entry created by lima without a digest file is verified
+	digestpath := filepath.Join(cachedir, cachedigestfile(file.Digest))
+	os.Remove(digestpath)
+	if image.Status() != drivercore.ImageStatusDownloaded {
+		t.Errorf("expected status %v without digest file, got %v", drivercore.ImageStatusDownloaded, image.Status())
+	}
+	if _, err := os.Stat(digestpath); err != nil {
+		t.Error("digest file not recorded after verification")
+	}
+
+	// A cache entry with a different digest is not usable
+	err = os.WriteFile(digestpath, []byte(testimagedigest("other")), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Status() != drivercore.ImageStatusNotDownloaded {
+		t.Errorf("expected status %v with wrong digest, got %v", drivercore.ImageStatusNotDownloaded, image.Status())
+	}
+
+	// Simulate `limactl prune`
+	os.RemoveAll(cachedir)
+	if image.Status() != drivercore.ImageStatusNotDownloaded {
+		t.Errorf("expected status %v after cache wipe, got %v", drivercore.ImageStatusNotDownloaded, image.Status())
+	}
+
+	err = image.Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = image.PurgeLocal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachedir); !os.IsNotExist(err) {
+		t.Error("cache entry not removed by PurgeLocal")
+	}
+	if image.Status() != drivercore.ImageStatusNotDownloaded {
+		t.Errorf("expected status %v after purge, got %v", drivercore.ImageStatusNotDownloaded, image.Status())
+	}
+}
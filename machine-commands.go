@@ -1,39 +1,49 @@
 package driverlima
 
 import (
+	"context"
+	"fmt"
 	"strings"
 
 	"github.com/kuttiproject/drivercore"
-	"github.com/kuttiproject/sshclient"
-)
-
-// TODO: Look at parameterizing these
-var (
-	limaUsername = "kuttiadmin"
-	limaPassword = "Pass@word1"
 )
 
 // runwithresults allows running commands inside a VM Host.
-// It does this by creating an SSH session with the host.
-func (vh *Machine) runwithresults(execpath string, paramarray ...string) (string, error) {
-	client := sshclient.NewWithPassword(limaUsername, limaPassword)
-	params := append([]string{execpath}, paramarray...)
-	output, err := client.RunWithResults(vh.SSHAddress(), strings.Join(params, " "))
+// It does this through Exec, which runs limactl shell. That uses the SSH
+// key and configuration that lima generated for the instance. So, no
+// password is needed, and the image may disable password authentication.
+func (vh *Machine) runwithresults(ctx context.Context, execpath string, paramarray ...string) (string, error) {
+	result, err := vh.Exec(
+		ctx,
+		append([]string{execpath}, paramarray...),
+		nil,
+	)
 	if err != nil {
 		return "", err
 	}
-	return output, nil
+
+	if result.ExitCode != 0 {
+		return "", fmt.Errorf(
+			"%v failed with exit code %v: %v",
+			execpath,
+			result.ExitCode,
+			strings.TrimSpace(string(result.Stderr)),
+		)
+	}
+
+	return string(result.Stdout), nil
 }
 
-var limaCommands = map[drivercore.PredefinedCommand]func(*Machine, ...string) error{
+var limaCommands = map[drivercore.PredefinedCommand]func(context.Context, *Machine, ...string) error{
 	drivercore.RenameMachine: renamemachine,
 }
 
-func renamemachine(vh *Machine, params ...string) error {
+func renamemachine(ctx context.Context, vh *Machine, params ...string) error {
 	newname := params[0]
 	execname := "set-hostname.sh"
 
 	_, err := vh.runwithresults(
+		ctx,
 		"/usr/bin/sudo",
 		execname,
 		newname,
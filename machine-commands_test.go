@@ -0,0 +1,39 @@
+package driverlima
+
+import (
+	"testing"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func TestRenameMachine(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "rename1")
+
+	if !m.ImplementsCommand(drivercore.RenameMachine) {
+		t.Fatal("expected RenameMachine to be implemented")
+	}
+
+	err := m.ExecuteCommand(drivercore.RenameMachine, "renamed")
+	if err == nil {
+		t.Error("expected error renaming a stopped machine")
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.ExecuteCommand(drivercore.RenameMachine, "renamed")
+	if err != nil {
+		t.Fatalf("RenameMachine failed: %v", err)
+	}
+
+	inst, err := loadfakeinstance(m.qName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Hostname != "renamed" {
+		t.Errorf("expected hostname renamed, got %v", inst.Hostname)
+	}
+}
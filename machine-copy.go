@@ -0,0 +1,275 @@
+package driverlima
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kuttiproject/drivercore"
+	"github.com/pkg/errors"
+)
+
+// copyCleanupTimeout limits the removal of a temporary directory after a
+// copy. The removal runs even if the copy was cancelled.
+const copyCleanupTimeout = 10 * time.Second
+
+// CopyOptions are optional settings for Machine.CopyTo and
+// Machine.CopyFrom.
+type CopyOptions struct {
+	// Recursive allows copying directories.
+	Recursive bool
+	// Sudo makes the copy in the Machine as root, so that files which
+	// the lima user cannot access can be read or written.
+	Sudo bool
+	// Mode, if not zero, is set on the copied files. Copied directories
+	// keep their mode.
+	Mode fs.FileMode
+	// Owner, if set, is the "user[:group]" that should own the copied
+	// files in the Machine. It implies Sudo, and is only valid for CopyTo.
+	Owner string
+}
+
+// CopyTo copies a file, or a directory if opts.Recursive is set, from the
+// host into this Machine, using limactl copy. The Machine must be running.
+// guestpath is the path of the copy in the Machine.
+func (m *Machine) CopyTo(hostpath string, guestpath string, opts *CopyOptions) error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.CopyToContext(ctx, hostpath, guestpath, opts)
+}
+
+// CopyToContext is like CopyTo, but stops copying when ctx is done.
+func (m *Machine) CopyToContext(ctx context.Context, hostpath string, guestpath string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+
+	err := m.checkcopy(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(hostpath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%v is a directory, and a recursive copy was not requested", hostpath)
+	}
+
+	if !opts.Sudo && opts.Owner == "" {
+		// Like cp, limactl copy puts the copy inside an existing directory
+		copiedpath := guestpath
+		if opts.Mode != 0 {
+			isdir, err := m.guestisdir(ctx, guestpath)
+			if err != nil {
+				return err
+			}
+			if isdir {
+				copiedpath = path.Join(guestpath, filepath.Base(hostpath))
+			}
+		}
+
+		err = m.limactlcopy(ctx, opts.Recursive, hostpath, m.qName()+":"+guestpath)
+		if err != nil {
+			return err
+		}
+
+		return m.guestchmod(ctx, copiedpath, opts, false)
+	}
+
+	// The lima user copies into a temporary directory, and root moves
+	// the copy into place
+	tempdir, err := m.guesttempdir(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.guestremove(tempdir)
+
+	tempcopy := path.Join(tempdir, filepath.Base(hostpath))
+	err = m.limactlcopy(ctx, opts.Recursive, hostpath, m.qName()+":"+tempcopy)
+	if err != nil {
+		return err
+	}
+
+	if opts.Owner != "" {
+		_, err = m.runwithresults(ctx, "sudo", "chown", "-R", opts.Owner, tempcopy)
+		if err != nil {
+			return errors.Wrap(err, "could not set owner of copied files")
+		}
+	}
+
+	err = m.guestchmod(ctx, tempcopy, opts, true)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.runwithresults(ctx, "sudo", "cp", "-Rp", tempcopy, guestpath)
+	if err != nil {
+		return errors.Wrapf(err, "could not copy files to %v", guestpath)
+	}
+
+	return nil
+}
+
+// CopyFrom copies a file, or a directory if opts.Recursive is set, from
+// this Machine to the host, using limactl copy. The Machine must be
+// running. hostpath is the path of the copy on the host.
+func (m *Machine) CopyFrom(guestpath string, hostpath string, opts *CopyOptions) error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.CopyFromContext(ctx, guestpath, hostpath, opts)
+}
+
+// CopyFromContext is like CopyFrom, but stops copying when ctx is done.
+func (m *Machine) CopyFromContext(ctx context.Context, guestpath string, hostpath string, opts *CopyOptions) error {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+
+	if opts.Owner != "" {
+		return fmt.Errorf("setting the owner of copied files is only supported when copying into a machine")
+	}
+
+	err := m.checkcopy(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	source := guestpath
+	if opts.Sudo {
+		// Root copies into a temporary directory, owned by the lima user,
+		// from which the lima user can copy
+		tempdir, err := m.guesttempdir(ctx)
+		if err != nil {
+			return err
+		}
+		defer m.guestremove(tempdir)
+
+		source = path.Join(tempdir, path.Base(guestpath))
+		_, err = m.runwithresults(ctx, "sudo", "cp", "-R", guestpath, source)
+		if err != nil {
+			return errors.Wrapf(err, "could not read %v", guestpath)
+		}
+
+		uid, err := m.runwithresults(ctx, "id", "-u")
+		if err != nil {
+			return err
+		}
+		_, err = m.runwithresults(ctx, "sudo", "chown", "-R", strings.TrimSpace(uid), source)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Like cp, limactl copy puts the copy inside an existing directory
+	copied := hostpath
+	if info, err := os.Stat(hostpath); err == nil && info.IsDir() {
+		copied = filepath.Join(hostpath, path.Base(guestpath))
+	}
+
+	err = m.limactlcopy(ctx, opts.Recursive, m.qName()+":"+source, hostpath)
+	if err != nil {
+		return err
+	}
+
+	if opts.Mode == 0 {
+		return nil
+	}
+
+	// The mode is for files. Directories keep theirs, so that they can
+	// still be traversed.
+	return filepath.WalkDir(copied, func(copiedpath string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		return os.Chmod(copiedpath, opts.Mode)
+	})
+}
+
+// checkcopy checks that files can be copied to or from this Machine.
+func (m *Machine) checkcopy(ctx context.Context, opts *CopyOptions) error {
+	if opts.Mode&^fs.ModePerm != 0 {
+		return fmt.Errorf("invalid mode %v: only permission bits can be set", opts.Mode)
+	}
+
+	status := m.StatusContext(ctx)
+	if status != drivercore.MachineStatusRunning {
+		return fmt.Errorf("can only copy files when machine %v is running, but it is %v", m.name, status)
+	}
+
+	return nil
+}
+
+func (m *Machine) limactlcopy(ctx context.Context, recursive bool, source string, target string) error {
+	limactlparams := []string{"copy"}
+	if recursive {
+		limactlparams = append(limactlparams, "-r")
+	}
+	limactlparams = append(limactlparams, source, target)
+
+	_, err := m.driver.runwithresults(ctx, limactlparams...)
+	if err != nil {
+		return errors.Wrapf(err, "could not copy %v to %v", source, target)
+	}
+
+	return nil
+}
+
+// guesttempdir creates a temporary directory in this Machine, owned by
+// the lima user.
+func (m *Machine) guesttempdir(ctx context.Context) (string, error) {
+	output, err := m.runwithresults(ctx, "mktemp", "-d")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create temporary directory in machine")
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// guestremove removes a temporary path in this Machine, waiting at most
+// copyCleanupTimeout.
+func (m *Machine) guestremove(guestpath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), copyCleanupTimeout)
+	defer cancel()
+	m.runwithresults(ctx, "sudo", "rm", "-rf", guestpath)
+}
+
+// guestisdir reports whether a path in this Machine is a directory.
+func (m *Machine) guestisdir(ctx context.Context, guestpath string) (bool, error) {
+	result, err := m.Exec(ctx, []string{"test", "-d", guestpath}, nil)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ExitCode == 0, nil
+}
+
+// guestchmod sets opts.Mode, if specified, on the regular files under a
+// path in this Machine. Directories keep their mode.
+func (m *Machine) guestchmod(ctx context.Context, guestpath string, opts *CopyOptions, sudo bool) error {
+	if opts.Mode == 0 {
+		return nil
+	}
+
+	argv := []string{
+		"find", guestpath, "-type", "f",
+		"-exec", "chmod", strconv.FormatUint(uint64(opts.Mode.Perm()), 8), "{}", "+",
+	}
+	if sudo {
+		argv = append([]string{"sudo"}, argv...)
+	}
+
+	_, err := m.runwithresults(ctx, argv[0], argv[1:]...)
+	if err != nil {
+		return errors.Wrap(err, "could not set mode of copied files")
+	}
+
+	return nil
+}
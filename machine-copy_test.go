@@ -0,0 +1,184 @@
+package driverlima
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake limactl copies files with cp")
+	}
+
+	d := testdriver(t)
+	m := testmachine(t, d, "copy1")
+
+	hostdir := t.TempDir()
+	guestdir := t.TempDir() // The fake shares the host's filesystem
+
+	hostfile := filepath.Join(hostdir, "kubeadm.yaml")
+	err := os.WriteFile(hostfile, []byte("kind: ClusterConfiguration\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.CopyTo(hostfile, filepath.Join(guestdir, "kubeadm.yaml"), nil)
+	if err == nil || !strings.Contains(err.Error(), "running") {
+		t.Errorf("expected error copying to a stopped machine, got %v", err)
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("file", func(t *testing.T) {
+		guestfile := filepath.Join(guestdir, "kubeadm.yaml")
+		err := m.CopyTo(hostfile, guestfile, &CopyOptions{Mode: 0600})
+		if err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		expectfile(t, guestfile, "kind: ClusterConfiguration\n", 0600)
+
+		copied := filepath.Join(hostdir, "copied.yaml")
+		err = m.CopyFrom(guestfile, copied, &CopyOptions{Mode: 0640})
+		if err != nil {
+			t.Fatalf("CopyFrom failed: %v", err)
+		}
+		expectfile(t, copied, "kind: ClusterConfiguration\n", 0640)
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		err := m.CopyTo(hostdir, filepath.Join(guestdir, "dir"), nil)
+		if err == nil || !strings.Contains(err.Error(), "recursive") {
+			t.Errorf("expected error copying a directory without Recursive, got %v", err)
+		}
+
+		err = m.CopyTo(hostdir, filepath.Join(guestdir, "dir"), &CopyOptions{Recursive: true})
+		if err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		expectfile(t, filepath.Join(guestdir, "dir", "kubeadm.yaml"), "kind: ClusterConfiguration\n", 0644)
+	})
+
+	t.Run("into existing directory", func(t *testing.T) {
+		targetdir := filepath.Join(hostdir, "target")
+		err := os.Mkdir(targetdir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sibling := filepath.Join(targetdir, "sibling.yaml")
+		err = os.WriteFile(sibling, []byte("sibling\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = m.CopyFrom(filepath.Join(guestdir, "kubeadm.yaml"), targetdir, &CopyOptions{Mode: 0600})
+		if err != nil {
+			t.Fatalf("CopyFrom failed: %v", err)
+		}
+		expectfile(t, filepath.Join(targetdir, "kubeadm.yaml"), "kind: ClusterConfiguration\n", 0600)
+		expectfile(t, sibling, "sibling\n", 0644)
+		expectmode(t, targetdir, 0755)
+
+		err = m.CopyTo(hostfile, targetdir, &CopyOptions{Mode: 0640})
+		if err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		expectfile(t, filepath.Join(targetdir, "kubeadm.yaml"), "kind: ClusterConfiguration\n", 0640)
+		expectfile(t, sibling, "sibling\n", 0644)
+		expectmode(t, targetdir, 0755)
+	})
+
+	t.Run("recursive with mode", func(t *testing.T) {
+		sourcedir := filepath.Join(t.TempDir(), "manifests")
+		err := os.MkdirAll(filepath.Join(sourcedir, "addons"), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(sourcedir, "addons", "cni.yaml"), []byte("kind: DaemonSet\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		guestmanifests := filepath.Join(guestdir, "manifests")
+		err = m.CopyTo(sourcedir, guestmanifests, &CopyOptions{Recursive: true, Mode: 0600})
+		if err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		expectfile(t, filepath.Join(guestmanifests, "addons", "cni.yaml"), "kind: DaemonSet\n", 0600)
+		expectmode(t, guestmanifests, 0755)
+		expectmode(t, filepath.Join(guestmanifests, "addons"), 0755)
+
+		copied := filepath.Join(t.TempDir(), "copied")
+		err = m.CopyFrom(guestmanifests, copied, &CopyOptions{Recursive: true, Mode: 0640})
+		if err != nil {
+			t.Fatalf("CopyFrom failed: %v", err)
+		}
+		expectfile(t, filepath.Join(copied, "addons", "cni.yaml"), "kind: DaemonSet\n", 0640)
+		expectmode(t, copied, 0755)
+		expectmode(t, filepath.Join(copied, "addons"), 0755)
+	})
+
+	t.Run("sudo and owner", func(t *testing.T) {
+		owner := fmt.Sprintf("%v:%v", os.Getuid(), os.Getgid())
+		guestfile := filepath.Join(guestdir, "owned.yaml")
+		err := m.CopyTo(hostfile, guestfile, &CopyOptions{Owner: owner, Mode: 0600})
+		if err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		expectfile(t, guestfile, "kind: ClusterConfiguration\n", 0600)
+
+		copied := filepath.Join(hostdir, "owned.yaml")
+		err = m.CopyFrom(guestfile, copied, &CopyOptions{Sudo: true})
+		if err != nil {
+			t.Fatalf("CopyFrom failed: %v", err)
+		}
+		expectfile(t, copied, "kind: ClusterConfiguration\n", 0600)
+
+		err = m.CopyFrom(guestfile, copied, &CopyOptions{Owner: owner})
+		if err == nil {
+			t.Error("expected error setting owner when copying from a machine")
+		}
+	})
+
+	err = m.CopyFrom(filepath.Join(guestdir, "missing"), filepath.Join(hostdir, "missing"), nil)
+	if err == nil {
+		t.Error("expected error copying a file that does not exist")
+	}
+
+	err = m.CopyTo(hostfile, filepath.Join(guestdir, "x"), &CopyOptions{Mode: os.ModeSetuid | 0755})
+	if err == nil {
+		t.Error("expected error for mode with more than permission bits")
+	}
+}
+
+func expectfile(t *testing.T, path string, content string, mode os.FileMode) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("expected %v to contain %q, got %q", path, content, data)
+	}
+
+	expectmode(t, path, mode)
+}
+
+func expectmode(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != mode {
+		t.Errorf("expected %v to have mode %v, got %v", path, mode, info.Mode().Perm())
+	}
+}
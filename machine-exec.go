@@ -0,0 +1,126 @@
+package driverlima
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExecOptions are optional settings for Machine.Exec.
+type ExecOptions struct {
+	// Stdin, if not nil, is the standard input of the command.
+	Stdin io.Reader
+	// Env contains additional environment variables for the command,
+	// in the form "KEY=value".
+	Env []string
+	// WorkDir is the directory in the Machine in which the command runs.
+	// The default is the root directory.
+	WorkDir string
+}
+
+// ExecResult is the outcome of a command run by Machine.Exec.
+type ExecResult struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+}
+
+// Exec runs a command inside this Machine, using limactl shell. The
+// Machine must be running. Unless ctx has an earlier deadline, the
+// command is stopped after the default timeout for limactl shell.
+// A command that runs and exits with a non-zero code is not an error:
+// the code is returned in the ExecResult. An error is returned if the
+// command could not be run, or if ctx is done before it completes.
+func (m *Machine) Exec(ctx context.Context, argv []string, opts *ExecOptions) (*ExecResult, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+
+	if opts == nil {
+		opts = &ExecOptions{}
+	}
+
+	workdir := opts.WorkDir
+	if workdir == "" {
+		// The current directory of the host may not exist in the VM
+		workdir = "/"
+	}
+
+	shellargs := []string{"shell", "--workdir", workdir, m.qName()}
+	if len(opts.Env) > 0 {
+		for _, variable := range opts.Env {
+			name, _, ok := strings.Cut(variable, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid environment variable %q", variable)
+			}
+		}
+		shellargs = append(shellargs, "env")
+		shellargs = append(shellargs, opts.Env...)
+	}
+	shellargs = append(shellargs, argv...)
+
+	err := m.driver.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = m.driver.runlimactl(ctx, &limactlRun{
+		args:   shellargs,
+		stdin:  opts.Stdin,
+		stdout: &stdout,
+		stderr: &stderr,
+	})
+
+	var timeouterr *TimeoutError
+	if errors.As(err, &timeouterr) || ctx.Err() != nil {
+		return nil, errors.Wrapf(err, "command %v in machine %v did not complete", argv[0], m.name)
+	}
+
+	entries, commandstderr := splitlimalog(stderr.Bytes())
+	result := &ExecResult{
+		Stdout: stdout.Bytes(),
+		Stderr: commandstderr,
+	}
+
+	var exiterr *exec.ExitError
+	if errors.As(err, &exiterr) {
+		// limactl reports its own failures as fatal log entries
+		for _, entry := range entries {
+			if entry.Level == "fatal" {
+				return nil, errors.Wrapf(
+					newlimactlerror("shell", err, entries),
+					"could not run %v in machine %v", argv[0], m.name,
+				)
+			}
+		}
+		result.ExitCode = exiterr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not run limactl")
+	}
+
+	return result, nil
+}
+
+// splitlimalog separates the JSON log entries that limactl writes on its
+// standard error from the standard error of the command it runs.
+func splitlimalog(output []byte) ([]LogEntry, []byte) {
+	var entries []LogEntry
+	var rest []byte
+	for _, line := range bytes.SplitAfter(output, []byte("\n")) {
+		kind, entry, _ := classifylimaline(strings.TrimRight(string(line), "\r\n"))
+		if kind == limaLineLogEntry {
+			entries = append(entries, *entry)
+			continue
+		}
+		rest = append(rest, line...)
+	}
+	return entries, rest
+}
@@ -0,0 +1,85 @@
+package driverlima
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake limactl runs guest commands on the host, which needs a POSIX shell")
+	}
+
+	d := testdriver(t)
+	m := testmachine(t, d, "exec1")
+
+	_, err := m.Exec(context.Background(), []string{"true"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "not \"Running\"") {
+		t.Errorf("expected error running a command in a stopped machine, got %v", err)
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("output and exit code", func(t *testing.T) {
+		result, err := m.Exec(
+			context.Background(),
+			[]string{"sh", "-c", "cat; echo oops >&2; exit 3"},
+			&ExecOptions{Stdin: strings.NewReader("hello")},
+		)
+		if err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+		if string(result.Stdout) != "hello" || string(result.Stderr) != "oops\n" || result.ExitCode != 3 {
+			t.Errorf("unexpected result: stdout %q, stderr %q, exit code %v", result.Stdout, result.Stderr, result.ExitCode)
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		result, err := m.Exec(
+			context.Background(),
+			[]string{"sh", "-c", "echo $GREETING"},
+			&ExecOptions{Env: []string{"GREETING=hi there"}},
+		)
+		if err != nil {
+			t.Fatalf("Exec failed: %v", err)
+		}
+		if string(result.Stdout) != "hi there\n" || result.ExitCode != 0 {
+			t.Errorf("unexpected result: stdout %q, exit code %v", result.Stdout, result.ExitCode)
+		}
+
+		_, err = m.Exec(context.Background(), []string{"true"}, &ExecOptions{Env: []string{"NOVALUE"}})
+		if err == nil {
+			t.Error("expected error for invalid environment variable")
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		// The fake runs sleep as a child process, which outlives it
+		savedwaitdelay := limactlWaitDelay
+		limactlWaitDelay = 100 * time.Millisecond
+		defer func() { limactlWaitDelay = savedwaitdelay }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := m.Exec(ctx, []string{"sleep", "10"}, nil)
+		if err == nil {
+			t.Error("expected error when context times out")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Exec returned %v after the context timed out", elapsed)
+		}
+	})
+
+	_, err = m.Exec(context.Background(), nil, nil)
+	if err == nil {
+		t.Error("expected error for empty command")
+	}
+}
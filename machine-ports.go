@@ -0,0 +1,132 @@
+package driverlima
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of ForwardedPort.
+const (
+	ForwardedPortSSH   = "ssh"
+	ForwardedPortRule  = "port"
+	ForwardedPortRange = "range"
+)
+
+// ForwardedPort is a rule forwarding host ports to Machine ports.
+// For a single port, the start and end ports are the same.
+type ForwardedPort struct {
+	Kind             string
+	Proto            string
+	HostIP           string
+	HostPortStart    int
+	HostPortEnd      int
+	MachinePortStart int
+	MachinePortEnd   int
+}
+
+// ForwardedPorts returns the port forwarding rules in effect for this
+// Machine: the SSH port, ports forwarded by ForwardPort, and any port
+// ranges. They are read from the configuration of the lima instance.
+// Where rules overlap, lima uses the first one.
+func (m *Machine) ForwardedPorts() ([]ForwardedPort, error) {
+	if m.limainfo == nil {
+		ctx, stop := interruptcontext()
+		defer stop()
+		m.get(ctx)
+	}
+
+	instancedir := ""
+	if m.limainfo != nil {
+		instancedir = m.limainfo.Dir
+	}
+	if instancedir == "" {
+		limahome, err := limaHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		instancedir = filepath.Join(limahome, m.qName())
+	}
+
+	data, err := os.ReadFile(filepath.Join(instancedir, "lima.yaml"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read lima instance configuration")
+	}
+
+	lm, err := parsemanifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ForwardedPort{}
+
+	// A localPort of 0 means lima picks a port when the instance starts
+	sshport := lm.SSH.LocalPort
+	if sshport == 0 && m.limainfo != nil {
+		sshport = m.limainfo.SSHLocalPort
+	}
+	if sshport != 0 {
+		result = append(result, ForwardedPort{
+			Kind:             ForwardedPortSSH,
+			Proto:            "tcp",
+			HostIP:           "127.0.0.1",
+			HostPortStart:    sshport,
+			HostPortEnd:      sshport,
+			MachinePortStart: 22,
+			MachinePortEnd:   22,
+		})
+	}
+
+	for _, rule := range lm.PortForwards {
+		if forward, ok := rule.forwardedport(); ok {
+			result = append(result, forward)
+		}
+	}
+
+	return result, nil
+}
+
+// forwardedport returns the effective host and guest ports of a rule,
+// applying lima's defaults. It returns false for rules which do not
+// forward TCP or UDP ports, or are invalid.
+func (pf *manifestForward) forwardedport() (ForwardedPort, bool) {
+	if pf.Ignore || pf.GuestSocket != "" || pf.HostSocket != "" || pf.validate() != nil {
+		return ForwardedPort{}, false
+	}
+
+	result := ForwardedPort{
+		Kind:   ForwardedPortRule,
+		Proto:  pf.Proto,
+		HostIP: pf.HostIP,
+	}
+	if result.Proto == "" {
+		result.Proto = "tcp"
+	}
+	if result.HostIP == "" {
+		result.HostIP = "127.0.0.1"
+	}
+
+	switch {
+	case pf.GuestPortRange != nil:
+		result.Kind = ForwardedPortRange
+		result.MachinePortStart, result.MachinePortEnd = pf.GuestPortRange[0], pf.GuestPortRange[1]
+	case pf.GuestPort != 0:
+		result.MachinePortStart, result.MachinePortEnd = pf.GuestPort, pf.GuestPort
+	default:
+		// A rule with no guest ports matches all of them
+		result.Kind = ForwardedPortRange
+		result.MachinePortStart, result.MachinePortEnd = 1, 65535
+	}
+
+	switch {
+	case pf.HostPortRange != nil:
+		result.HostPortStart, result.HostPortEnd = pf.HostPortRange[0], pf.HostPortRange[1]
+	case pf.HostPort != 0:
+		result.HostPortStart, result.HostPortEnd = pf.HostPort, pf.HostPort
+	default:
+		result.HostPortStart, result.HostPortEnd = result.MachinePortStart, result.MachinePortEnd
+	}
+
+	return result, true
+}
@@ -0,0 +1,73 @@
+package driverlima
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestForwardedPorts(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "ports1")
+
+	err := m.ForwardSSHPort(10222)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ForwardPort(18280, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ports, err := m.ForwardedPorts()
+	if err != nil {
+		t.Fatalf("ForwardedPorts failed: %v", err)
+	}
+
+	expected := []ForwardedPort{
+		{ForwardedPortSSH, "tcp", "127.0.0.1", 10222, 10222, 22, 22},
+		{ForwardedPortRule, "tcp", "0.0.0.0", 18280, 18280, 80, 80},
+	}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %+v, got %+v", expected, ports)
+	}
+}
+
+func TestForwardedPortDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     manifestForward
+		expected ForwardedPort
+		ok       bool
+	}{
+		{
+			"single port",
+			manifestForward{GuestPort: 443},
+			ForwardedPort{ForwardedPortRule, "tcp", "127.0.0.1", 443, 443, 443, 443},
+			true,
+		},
+		{
+			"udp port range",
+			manifestForward{GuestPortRange: []int{4000, 4999}, Proto: "udp", HostIP: "0.0.0.0"},
+			ForwardedPort{ForwardedPortRange, "udp", "0.0.0.0", 4000, 4999, 4000, 4999},
+			true,
+		},
+		{
+			"all ports",
+			manifestForward{GuestIP: "127.0.0.2", HostIP: "127.0.0.2"},
+			ForwardedPort{ForwardedPortRange, "tcp", "127.0.0.2", 1, 65535, 1, 65535},
+			true,
+		},
+		{"ignore", manifestForward{Ignore: true}, ForwardedPort{}, false},
+		{"socket", manifestForward{GuestSocket: "/run/docker.sock", HostSocket: "docker.sock"}, ForwardedPort{}, false},
+		{"invalid", manifestForward{GuestPortRange: []int{4000}}, ForwardedPort{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, ok := test.rule.forwardedport()
+			if ok != test.ok || actual != test.expected {
+				t.Errorf("expected %+v, %v, got %+v, %v", test.expected, test.ok, actual, ok)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package driverlima
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/kuttiproject/drivercore"
 	"github.com/kuttiproject/kuttilog"
@@ -30,7 +33,14 @@ func (m *Machine) Name() string {
 // Status can be drivercore.MachineStatusRunning, drivercore.MachineStatusStopped
 // drivercore.MachineStatusUnknown or drivercore.MachineStatusError.
 func (m *Machine) Status() drivercore.MachineStatus {
-	m.get()
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.StatusContext(ctx)
+}
+
+// StatusContext is like Status, but stops querying lima when ctx is done.
+func (m *Machine) StatusContext(ctx context.Context) drivercore.MachineStatus {
+	m.get(ctx)
 	return m.status
 }
 
@@ -39,7 +49,9 @@ func (m *Machine) Status() drivercore.MachineStatus {
 // drivercore.MachineStatusError.
 func (m *Machine) Error() string {
 	if m.limainfo == nil {
-		m.get()
+		ctx, stop := interruptcontext()
+		defer stop()
+		m.get(ctx)
 	}
 	return m.errormessage
 }
@@ -48,9 +60,17 @@ func (m *Machine) Error() string {
 // A valid value can be expected only when Status() returns
 // drivercore.MachineStatusRunning.
 func (m *Machine) IPAddress() string {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.IPAddressContext(ctx)
+}
+
+// IPAddressContext is like IPAddress, but stops querying the Machine when
+// ctx is done.
+func (m *Machine) IPAddressContext(ctx context.Context) string {
 	kuttilog.Println(kuttilog.MaxLevel(), "In ipaddress 1")
 
-	status := m.Status()
+	status := m.StatusContext(ctx)
 	if status != drivercore.MachineStatusRunning {
 		return ""
 	}
@@ -58,13 +78,7 @@ func (m *Machine) IPAddress() string {
 	kuttilog.Println(kuttilog.MaxLevel(), "In ipaddress 2")
 
 	//return "0.0.1.0"
-	limactlargs := []string{
-		"shell",
-		m.qName(),
-		"get-primary-ip.sh",
-	}
-
-	result, err := m.driver.runwithresults(limactlargs...)
+	result, err := m.runwithresults(ctx, "get-primary-ip.sh")
 	if err != nil {
 		kuttilog.Printf(kuttilog.Error, "Error fetching ipaddess: %v", err)
 		m.status = drivercore.MachineStatusError
@@ -72,30 +86,59 @@ func (m *Machine) IPAddress() string {
 		return err.Error()
 	}
 
-	if !result.isRaw {
-		return "unexpected format error fetching ipaddress"
-	}
-
-	return strings.TrimRight(result.rawResult, "\n")
+	return strings.TrimRight(result, "\n")
 }
 
 // SSHAddress returns the host address and port number to SSH into this Machine.
 // For drivers that use NAT netwoking, the host address will be 'localhost'.
+// The port is the one reported by lima. If the SSH port has not been
+// forwarded explicitly, lima picks a port each time the Machine starts,
+// so the port is known only while the Machine is running.
 func (m *Machine) SSHAddress() string {
+	ctx, stop := interruptcontext()
+	defer stop()
+	m.get(ctx)
 	return fmt.Sprintf("localhost:%v", m.sshhostport)
 }
 
+// Resources returns the CPU count, memory and disk size of this Machine,
+// as reported by lima.
+func (m *Machine) Resources() MachineResources {
+	if m.limainfo == nil {
+		ctx, stop := interruptcontext()
+		defer stop()
+		m.get(ctx)
+	}
+
+	if m.limainfo == nil {
+		return MachineResources{}
+	}
+
+	return MachineResources{
+		CPUs:      m.limainfo.CPUs,
+		MemoryMiB: int(m.limainfo.Memory >> 20),
+		DiskGiB:   int(m.limainfo.Disk >> 30),
+	}
+}
+
 // Start starts a Machine.
 // Note that a Machine may not be ready for further operations at the end of this,
 // and therefore its status may not change immediately.
 // See WaitForStateChange().
 func (m *Machine) Start() error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.StartContext(ctx)
+}
+
+// StartContext is like Start, but kills limactl if ctx is done first.
+func (m *Machine) StartContext(ctx context.Context) error {
 	limctlparams := []string{
 		"start",
 		m.qName(),
 	}
 
-	_, err := m.driver.runwithresults(limctlparams...)
+	_, err := m.driver.runwithresults(ctx, limctlparams...)
 	if err != nil {
 		return err
 	}
@@ -109,12 +152,19 @@ func (m *Machine) Start() error {
 // and therefore its status will not change immediately.
 // See WaitForStateChange().
 func (m *Machine) Stop() error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.StopContext(ctx)
+}
+
+// StopContext is like Stop, but kills limactl if ctx is done first.
+func (m *Machine) StopContext(ctx context.Context) error {
 	limctlparams := []string{
 		"stop",
 		m.qName(),
 	}
 
-	_, err := m.driver.runwithresults(limctlparams...)
+	_, err := m.driver.runwithresults(ctx, limctlparams...)
 	if err != nil {
 		return err
 	}
@@ -126,13 +176,21 @@ func (m *Machine) Stop() error {
 // ForceStop stops a Machine forcibly.
 // This operation should set the status to drivercore.MachineStatusStopped.
 func (m *Machine) ForceStop() error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.ForceStopContext(ctx)
+}
+
+// ForceStopContext is like ForceStop, but kills limactl if ctx is done
+// first.
+func (m *Machine) ForceStopContext(ctx context.Context) error {
 	limctlparams := []string{
 		"stop",
 		"-f",
 		m.qName(),
 	}
 
-	_, err := m.driver.runwithresults(limctlparams...)
+	_, err := m.driver.runwithresults(ctx, limctlparams...)
 	if err != nil {
 		return err
 	}
@@ -141,40 +199,165 @@ func (m *Machine) ForceStop() error {
 	return nil
 }
 
+// Intervals used by WaitForStateChange when polling limactl.
+// The interval doubles after every poll, up to the maximum.
+var (
+	waitPollInitialInterval = 250 * time.Millisecond
+	waitPollMaxInterval     = 4 * time.Second
+)
+
 // WaitForStateChange waits the specified number of seconds, or until the Machine
 // status changes.
 // WaitForStateChange should be called after calls to Start() or Stop(), before
 // any other operation. It should not be called _before_ Stop().
-// The lima driver silently ignores WaitForStateChanged.
+// The lima driver polls limactl, with backoff, until the status differs from
+// the status reported by limactl when it is called. Polls that fail are
+// retried, unless the instance does not exist. If the timeout elapses first,
+// the timeout is reported by Error().
 func (m *Machine) WaitForStateChange(timeoutinseconds int) {
+	ctx, stop := interruptcontext()
+	defer stop()
+	m.WaitForStateChangeContext(ctx, timeoutinseconds)
+}
+
+// WaitForStateChangeContext is like WaitForStateChange, but also returns
+// when ctx is done. In that case, the reason is reported by Error().
+func (m *Machine) WaitForStateChangeContext(ctx context.Context, timeoutinseconds int) {
+	deadline := time.Now().Add(time.Duration(timeoutinseconds) * time.Second)
+	interval := waitPollInitialInterval
+
+	// The status last observed may be out of date
+	err := m.get(ctx)
+	if errors.Is(err, ErrInstanceNotFound) {
+		return
+	}
+	prevstatus := m.status
+
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+
+		timer := time.NewTimer(min(interval, remaining))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			m.errormessage = fmt.Sprintf(
+				"stopped waiting for status of machine %v to change from %v: %v",
+				m.name,
+				prevstatus,
+				ctx.Err(),
+			)
+			return
+		case <-timer.C:
+		}
+		interval = min(interval*2, waitPollMaxInterval)
+
+		err = m.get(ctx)
+		switch {
+		case errors.Is(err, ErrInstanceNotFound):
+			return
+		case err != nil:
+			// A failed poll is not a change of status
+		case prevstatus == drivercore.MachineStatusError:
+			// Nor is the first successful one, if the status before the
+			// call could not be read
+			prevstatus = m.status
+		case m.status != prevstatus:
+			return
+		}
+	}
 
+	m.errormessage = fmt.Sprintf(
+		"timed out after %v seconds waiting for status of machine %v to change from %v",
+		timeoutinseconds,
+		m.name,
+		prevstatus,
+	)
 }
 
 // ForwardPort creates a rule to forward the specified Machine port to the
 // specified physical host port.
+// If the host port is already in use, a *PortConflictError is returned.
+// The rule is added to the portForwards in the machine's lima manifest
+// and instance configuration, so it persists across restarts. Lima only
+// reads port forwarding rules when an instance starts, so the Machine must
+// be stopped.
 func (m *Machine) ForwardPort(hostport int, machineport int) error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.ForwardPortContext(ctx, hostport, machineport)
+}
+
+// ForwardPortContext is like ForwardPort, but kills limactl if ctx is
+// done first.
+func (m *Machine) ForwardPortContext(ctx context.Context, hostport int, machineport int) error {
 	if machineport == 22 {
-		return m.ForwardSSHPort(hostport)
+		return m.ForwardSSHPortContext(ctx, hostport)
 	}
 
-	kuttilog.Println(kuttilog.Verbose, "ForwardPort not explicitly required by lima driver")
-	return nil
+	err := validport(hostport, false)
+	if err == nil {
+		err = validport(machineport, false)
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.updateportforwards(ctx, func(lm *limaManifest) (bool, error) {
+		err := m.driver.checkhostport(ctx, hostport, m.qName(), machineport)
+		if err != nil {
+			return false, err
+		}
+
+		lm.setforward(hostport, machineport)
+		return true, nil
+	})
 }
 
 // UnforwardPort removes the rule which forwarded the specified Machine port.
+// Like ForwardPort, it requires the Machine to be stopped.
 func (m *Machine) UnforwardPort(machineport int) error {
-	kuttilog.Println(kuttilog.Verbose, "UnforwardPort not explicitly required by lima driver")
-	return nil
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.UnforwardPortContext(ctx, machineport)
+}
+
+// UnforwardPortContext is like UnforwardPort, but kills limactl if ctx is
+// done first.
+func (m *Machine) UnforwardPortContext(ctx context.Context, machineport int) error {
+	return m.updateportforwards(ctx, func(lm *limaManifest) (bool, error) {
+		removed := lm.removeforward(machineport)
+		if !removed {
+			kuttilog.Printf(kuttilog.Verbose, "Machine port %v is not forwarded.", machineport)
+		}
+		return removed, nil
+	})
 }
 
 // ForwardSSHPort forwards the SSH port of this Machine to the specified
 // physical host port.
+// If the host port is already in use, a *PortConflictError is returned.
 func (m *Machine) ForwardSSHPort(hostport int) error {
-	status := m.Status()
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.ForwardSSHPortContext(ctx, hostport)
+}
+
+// ForwardSSHPortContext is like ForwardSSHPort, but kills limactl if ctx
+// is done first.
+func (m *Machine) ForwardSSHPortContext(ctx context.Context, hostport int) error {
+	status := m.StatusContext(ctx)
 	if status != drivercore.MachineStatusStopped {
 		return fmt.Errorf("can only forward ports when machine is stopped")
 	}
 
+	err := m.driver.checkhostport(ctx, hostport, m.qName(), 22)
+	if err != nil {
+		return err
+	}
+
 	machinefile, err := machineFilePath(m.qName())
 	if err != nil {
 		return err
@@ -189,18 +372,74 @@ func (m *Machine) ForwardSSHPort(hostport int) error {
 		"--set",
 		fmt.Sprintf(".ssh.localPort = %v", hostport),
 	}
-	_, err = vd.runwithresults(limactlparams...)
+	_, err = vd.runwithresults(ctx, limactlparams...)
 	if err != nil {
 		return errors.Wrap(err, "could not update port forwarding in machine file")
 	}
 
 	// Set hostPort in created VM
 	limactlparams[1] = m.qName()
-	_, err = vd.runwithresults(limactlparams...)
+	_, err = vd.runwithresults(ctx, limactlparams...)
 	if err != nil {
 		return errors.Wrap(err, "could not update port forwarding in lima vm")
 	}
 
+	m.sshhostport = hostport
+	return nil
+}
+
+// updateportforwards changes the port forwarding rules in the machine's
+// lima manifest, and applies them to the lima instance if update returns
+// true.
+func (m *Machine) updateportforwards(ctx context.Context, update func(lm *limaManifest) (bool, error)) error {
+	status := m.StatusContext(ctx)
+	if status != drivercore.MachineStatusStopped {
+		return fmt.Errorf("can only forward ports when machine is stopped")
+	}
+
+	machinefile, err := machineFilePath(m.qName())
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(machinefile)
+	if err != nil {
+		return errors.Wrap(err, "could not read machine file")
+	}
+
+	lm, err := parsemanifest(data)
+	if err != nil {
+		return err
+	}
+
+	changed, err := update(lm)
+	if err != nil || !changed {
+		return err
+	}
+
+	expression, err := lm.portforwardsexpression()
+	if err != nil {
+		return err
+	}
+
+	// Set portForwards in created VM
+	limactlparams := []string{
+		"edit",
+		m.qName(),
+		"--set",
+		expression,
+	}
+	_, err = m.driver.runwithresults(ctx, limactlparams...)
+	if err != nil {
+		return errors.Wrap(err, "could not update port forwarding in lima vm")
+	}
+
+	// Set portForwards in manifest file
+	err = writemanifest(machinefile, lm)
+	if err != nil {
+		return errors.Wrap(err, "could not update port forwarding in machine file")
+	}
+
 	return nil
 }
 
@@ -213,6 +452,14 @@ func (m *Machine) ImplementsCommand(command drivercore.PredefinedCommand) bool {
 
 // ExecuteCommand executes the specified predefined operation.
 func (m *Machine) ExecuteCommand(command drivercore.PredefinedCommand, params ...string) error {
+	ctx, stop := interruptcontext()
+	defer stop()
+	return m.ExecuteCommandContext(ctx, command, params...)
+}
+
+// ExecuteCommandContext is like ExecuteCommand, but stops the operation
+// when ctx is done.
+func (m *Machine) ExecuteCommandContext(ctx context.Context, command drivercore.PredefinedCommand, params ...string) error {
 	commandfunc, ok := limaCommands[command]
 	if !ok {
 		return fmt.Errorf(
@@ -221,14 +468,18 @@ func (m *Machine) ExecuteCommand(command drivercore.PredefinedCommand, params ..
 		)
 	}
 
-	return commandfunc(m, params...)
+	return commandfunc(ctx, m, params...)
 }
 
 func (m *Machine) qName() string {
 	return m.driver.QualifiedMachineName(m.name, m.clustername)
 }
 
-func (m *Machine) get() {
+// get refreshes the status of this Machine from lima. If the lima
+// instance does not exist, the status is set to
+// drivercore.MachineStatusError, and an error matching
+// ErrInstanceNotFound is returned.
+func (m *Machine) get(ctx context.Context) error {
 	limactlargs := []string{
 		"list",
 		m.qName(),
@@ -236,15 +487,33 @@ func (m *Machine) get() {
 		"json",
 	}
 
-	resultobj, err := m.driver.runwithresults(limactlargs...)
+	resultobj, err := m.driver.runwithresults(ctx, limactlargs...)
 	if err != nil {
 		m.status = drivercore.MachineStatusError
 		m.errormessage = err.Error()
-		return
+		return err
 	}
 
-	result := resultobj.machineInfos[0]
+	// limactl list only logs a warning for an instance that does not exist
+	result := resultobj.instance(m.qName())
+	if result == nil {
+		err = fmt.Errorf("machine %v in cluster %v: %w", m.name, m.clustername, ErrInstanceNotFound)
+		m.limainfo = nil
+		m.sshhostport = 0
+		m.status = drivercore.MachineStatusError
+		m.errormessage = err.Error()
+		return err
+	}
 
+	m.limainfo = result
 	m.status = drivercore.MachineStatus(result.Status)
 	m.errormessage = ""
+
+	// A stopped instance reports the configured port, if any
+	m.sshhostport = result.SSHLocalPort
+	if m.sshhostport == 0 && result.Config != nil {
+		m.sshhostport = result.Config.SSH.LocalPort
+	}
+
+	return nil
 }
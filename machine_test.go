@@ -0,0 +1,368 @@
+package driverlima
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kuttiproject/drivercore"
+)
+
+func TestWaitForStateChange(t *testing.T) {
+	oldinitial, oldmax := waitPollInitialInterval, waitPollMaxInterval
+	waitPollInitialInterval, waitPollMaxInterval = 10*time.Millisecond, 40*time.Millisecond
+	t.Cleanup(func() {
+		waitPollInitialInterval, waitPollMaxInterval = oldinitial, oldmax
+	})
+
+	d := testdriver(t)
+
+	t.Run("status changes", func(t *testing.T) {
+		m := testmachine(t, d, "wait1")
+		setfakestatus(t, m.qName(), "Running", 3)
+
+		m.WaitForStateChange(10)
+
+		if m.status != drivercore.MachineStatusRunning {
+			t.Errorf("expected status %v, got %v", drivercore.MachineStatusRunning, m.status)
+		}
+		if m.Error() != "" {
+			t.Errorf("expected no error, got %q", m.Error())
+		}
+	})
+
+	t.Run("stale status", func(t *testing.T) {
+		// The status last observed is not the status before the call
+		m := testmachine(t, d, "wait3")
+		m.status = drivercore.MachineStatusRunning
+		setfakestatus(t, m.qName(), "Running", 3)
+
+		m.WaitForStateChange(10)
+
+		if m.status != drivercore.MachineStatusRunning {
+			t.Errorf("expected status %v, got %v", drivercore.MachineStatusRunning, m.status)
+		}
+	})
+
+	t.Run("failed poll", func(t *testing.T) {
+		m := testmachine(t, d, "wait4")
+		setfakestatus(t, m.qName(), "Running", 3)
+		inst, err := loadfakeinstance(m.qName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		inst.FailNextList = true
+		err = savefakeinstance(m.qName(), inst)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m.WaitForStateChange(10)
+
+		if m.status != drivercore.MachineStatusRunning {
+			t.Errorf("expected status %v, got %v", drivercore.MachineStatusRunning, m.status)
+		}
+		if m.Error() != "" {
+			t.Errorf("expected no error, got %q", m.Error())
+		}
+	})
+
+	t.Run("deleted instance", func(t *testing.T) {
+		m := testmachine(t, d, "wait5")
+		_, err := d.runwithresults(context.Background(), "rm", "-f", m.qName())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		m.WaitForStateChange(10)
+		elapsed := time.Since(start)
+
+		if elapsed > 5*time.Second {
+			t.Errorf("waited %v for a deleted instance", elapsed)
+		}
+		if !strings.Contains(m.Error(), "not found") {
+			t.Errorf("expected a not found error, got %q", m.Error())
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		m := testmachine(t, d, "wait2")
+		setfakestatus(t, m.qName(), "Running", 1000)
+
+		start := time.Now()
+		m.WaitForStateChange(1)
+		elapsed := time.Since(start)
+
+		if elapsed < time.Second {
+			t.Errorf("returned after %v, before the timeout", elapsed)
+		}
+		if m.status != drivercore.MachineStatusStopped {
+			t.Errorf("expected status %v, got %v", drivercore.MachineStatusStopped, m.status)
+		}
+		if !strings.Contains(m.Error(), "timed out") {
+			t.Errorf("expected timeout error, got %q", m.Error())
+		}
+	})
+}
+
+func TestForwardSSHPort(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "ssh1")
+
+	err := m.ForwardSSHPort(10022)
+	if err != nil {
+		t.Fatalf("ForwardSSHPort failed: %v", err)
+	}
+
+	config := fakeinstanceconfig(t, m.qName())
+	ssh, _ := config["ssh"].(map[string]any)
+	if ssh["localPort"] != 10022 {
+		t.Errorf("expected instance ssh.localPort 10022, got %v", ssh["localPort"])
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.ForwardSSHPort(10023)
+	if err == nil {
+		t.Error("expected error forwarding SSH port of a running machine")
+	}
+}
+
+func TestIPAddress(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "ip1")
+
+	if ip := m.IPAddress(); ip != "" {
+		t.Errorf("expected no IP address for stopped machine, got %q", ip)
+	}
+
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := m.IPAddress()
+	if !strings.HasPrefix(ip, "192.168.104.") {
+		t.Errorf("unexpected IP address %q", ip)
+	}
+}
+
+func TestForwardPort(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "fwd1")
+
+	forwards := func() []any {
+		t.Helper()
+		rules, _ := fakeinstanceconfig(t, m.qName())["portForwards"].([]any)
+		return rules
+	}
+	rule := func(rules []any, i int) map[string]any {
+		t.Helper()
+		if i >= len(rules) {
+			t.Fatalf("expected at least %v port forwarding rules, got %v", i+1, rules)
+		}
+		return rules[i].(map[string]any)
+	}
+
+	// NodePorts are not forwarded as a range, only the ignore rule is
+	// in the base manifest
+	basecount := len(forwards())
+	if basecount != 1 || rule(forwards(), 0)["ignore"] != true {
+		t.Fatalf("expected only the ignore rule in a new machine, got %v", forwards())
+	}
+
+	err := m.ForwardPort(18080, 80)
+	if err != nil {
+		t.Fatalf("ForwardPort failed: %v", err)
+	}
+	err = m.ForwardPort(18443, 443)
+	if err != nil {
+		t.Fatalf("ForwardPort failed: %v", err)
+	}
+
+	rules := forwards()
+	if len(rules) != basecount+2 {
+		t.Fatalf("expected %v rules, got %v", basecount+2, rules)
+	}
+	if r := rule(rules, 0); r["guestPort"] != 80 || r["hostPort"] != 18080 {
+		t.Errorf("unexpected first rule %v", r)
+	}
+	if r := rule(rules, 1); r["guestPort"] != 443 || r["hostPort"] != 18443 {
+		t.Errorf("unexpected second rule %v", r)
+	}
+	if r := rule(rules, len(rules)-1); r["ignore"] != true {
+		t.Errorf("expected ignore rule last, got %v", r)
+	}
+
+	// Forwarding again changes the host port
+	err = m.ForwardPort(19080, 80)
+	if err != nil {
+		t.Fatalf("ForwardPort failed: %v", err)
+	}
+	rules = forwards()
+	if len(rules) != basecount+2 || rule(rules, 0)["hostPort"] != 19080 {
+		t.Errorf("expected host port of existing rule to change, got %v", rules)
+	}
+
+	err = m.UnforwardPort(80)
+	if err != nil {
+		t.Fatalf("UnforwardPort failed: %v", err)
+	}
+	rules = forwards()
+	if len(rules) != basecount+1 || rule(rules, 0)["guestPort"] != 443 {
+		t.Errorf("expected rule for port 80 to be removed, got %v", rules)
+	}
+
+	err = m.UnforwardPort(80)
+	if err != nil {
+		t.Errorf("expected no error removing a rule that does not exist, got %v", err)
+	}
+
+	// The machine file is updated too
+	machinefile, err := machineFilePath(m.qName())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(machinefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, err := parsemanifest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lm.PortForwards) != basecount+1 || lm.PortForwards[0].GuestPort != 443 {
+		t.Errorf("unexpected port forwards in machine file: %+v", lm.PortForwards)
+	}
+
+	err = m.ForwardPort(0, 80)
+	if err == nil {
+		t.Error("expected error forwarding to an invalid host port")
+	}
+
+	err = m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.ForwardPort(18080, 80)
+	if err == nil {
+		t.Error("expected error forwarding a port of a running machine")
+	}
+}
+
+func TestSSHAddress(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "sshaddr1")
+
+	if address := m.SSHAddress(); address != "localhost:0" {
+		t.Errorf("expected no SSH port for stopped machine without a forwarded port, got %v", address)
+	}
+
+	err := m.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new Machine object for the same instance gets the port from lima
+	dm, err := d.GetMachine("sshaddr1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("localhost:%v", fakesshport(m.qName()))
+	if address := dm.SSHAddress(); address != expected {
+		t.Errorf("expected SSH address %v, got %v", expected, address)
+	}
+
+	err = m.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.ForwardSSHPort(10422)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if address := dm.SSHAddress(); address != "localhost:10422" {
+		t.Errorf("expected forwarded SSH port after refresh, got %v", address)
+	}
+	if m.Status(); m.sshhostport != 10422 {
+		t.Errorf("expected status refresh to keep SSH port, got %v", m.sshhostport)
+	}
+}
+
+func TestMachineGet(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "get3")
+
+	otherrow := `{"name":"test-get4","hostname":"lima-test-get4","status":"Running","dir":"/tmp/test-get4","sshLocalPort":60022,"sshConfigFile":"/tmp/test-get4/ssh.config"}`
+	matchingrow := `{"name":"test-get3","hostname":"lima-test-get3","status":"Stopped","dir":"/tmp/test-get3","sshLocalPort":0,"sshConfigFile":"/tmp/test-get3/ssh.config"}`
+	warning := `{"level":"warning","msg":"No instance matching test-get3 found.","time":"2025-01-01T00:00:00Z"}`
+
+	tests := []struct {
+		name           string
+		stdout         string
+		stderr         string
+		expectedstatus drivercore.MachineStatus
+	}{
+		{"no output", "", "", drivercore.MachineStatusError},
+		{"log only", "", warning + "\n", drivercore.MachineStatusError},
+		{"other instance only", otherrow + "\n", "", drivercore.MachineStatusError},
+		{"multiple rows", otherrow + "\n" + matchingrow + "\n", "", drivercore.MachineStatusStopped},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setfakelistoutput(t, test.stdout, test.stderr)
+
+			err := m.get(context.Background())
+			if m.status != test.expectedstatus {
+				t.Errorf("expected status %v, got %v", test.expectedstatus, m.status)
+			}
+			if test.expectedstatus == drivercore.MachineStatusError {
+				if !errors.Is(err, ErrInstanceNotFound) {
+					t.Errorf("expected ErrInstanceNotFound, got %v", err)
+				}
+				if !strings.Contains(m.Error(), "not found") {
+					t.Errorf("expected a not found error message, got %q", m.Error())
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("get failed: %v", err)
+			}
+			if m.limainfo == nil || m.limainfo.Name != m.qName() {
+				t.Errorf("expected instance %v, got %+v", m.qName(), m.limainfo)
+			}
+		})
+	}
+}
+
+func TestStatusOfDeletedInstance(t *testing.T) {
+	d := testdriver(t)
+	m := testmachine(t, d, "get5")
+
+	// Delete the instance behind kutti's back
+	_, err := d.runwithresults(context.Background(), "rm", "-f", m.qName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := m.Status(); status != drivercore.MachineStatusError {
+		t.Errorf("expected status %v for a deleted instance, got %v", drivercore.MachineStatusError, status)
+	}
+	if address := m.SSHAddress(); address != "localhost:0" {
+		t.Errorf("expected no SSH port for a deleted instance, got %v", address)
+	}
+	if resources := m.Resources(); resources != (MachineResources{}) {
+		t.Errorf("expected no resources for a deleted instance, got %+v", resources)
+	}
+}
@@ -0,0 +1,61 @@
+package driverlima
+
+import (
+	"net"
+
+	"github.com/kuttiproject/kuttilog"
+)
+
+// Network implements the drivercore.Network interface for lima.
+// Each cluster network is a lima user-v2 network, defined in lima's
+// networks.yaml.
+type Network struct {
+	name        string
+	clustername string
+	netCIDR     string
+}
+
+// Name is the name of the network.
+func (n *Network) Name() string {
+	return n.name
+}
+
+// CIDR is the network's IPv4 address range.
+func (n *Network) CIDR() string {
+	return n.netCIDR
+}
+
+// SetCIDR changes the network's IPv4 address range.
+// The range must not overlap any other lima network, any network on the
+// host, or any other cluster's network. Machines on the network get
+// addresses in the new range when they are next started.
+func (n *Network) SetCIDR(cidr string) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		kuttilog.Printf(kuttilog.Error, "Error setting network CIDR: %v", err)
+		return
+	}
+
+	network, err := newusernetwork(subnet)
+	if err != nil {
+		kuttilog.Printf(kuttilog.Error, "Error setting network CIDR: %v", err)
+		return
+	}
+
+	err = withnetworkconfig(func(nc *limaNetworkConfig) (bool, error) {
+		err := withsubnetallocator(nc, n.name, func(sa *subnetAllocator) error {
+			return sa.reserve(n.clustername, subnet)
+		})
+		if err != nil {
+			return false, err
+		}
+
+		return true, nc.set(n.name, network)
+	})
+	if err != nil {
+		kuttilog.Printf(kuttilog.Error, "Error setting network CIDR: %v", err)
+		return
+	}
+
+	n.netCIDR = subnet.String()
+}